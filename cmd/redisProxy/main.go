@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"os"
+	"os/signal"
+	"runtime"
+	"syscall"
+	"time"
+	"redisProxy/logger"
+	"redisProxy/module"
+)
+
+/*
+	redisProxy entry
+	usage: redisProxy -config redisProxy.json
+	SIGHUP reloads the config file,
+	SIGINT/SIGTERM drain the clients for at most -shutdown-timeout before exiting
+ */
+func main() {
+	path := flag.String("config", "redisProxy.json", "path of the proxy config file")
+	shutdownTimeout := flag.Duration("shutdown-timeout", 30*time.Second, "time to drain clients on SIGINT/SIGTERM")
+	flag.Parse()
+
+	nCpu := runtime.NumCPU()
+	runtime.GOMAXPROCS(nCpu)
+	config, err := module.LoadConfig(*path)
+	if err != nil {
+		// 配置加载前按默认格式输出日志
+		logger.New(os.Stderr, false, logger.InfoLevel).Error("cannot load config", "path", *path, "err", err)
+		os.Exit(1)
+	}
+	log := config.Log.Logger(os.Stderr)
+	server, err := module.NewServerWithLogger(config, log)
+	if err != nil {
+		log.Error("cannot create server", "err", err)
+		os.Exit(1)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+		for sig := range signals {
+			if sig == syscall.SIGHUP {
+				if err := server.ReloadFile(); err != nil {
+					log.Error("config reload failed", "err", err)
+				}
+				continue
+			}
+			log.Info("shutting down", "signal", sig, "timeout", *shutdownTimeout)
+			ctx, cancel := context.WithTimeout(context.Background(), *shutdownTimeout)
+			if err := server.Shutdown(ctx); err != nil {
+				log.Warn("clients closed before they were drained", "err", err)
+			}
+			cancel()
+			close(done)
+			return
+		}
+	}()
+	if err := server.Listen(); err != module.ErrServerClosed {
+		log.Error("listen failed", "err", err)
+		os.Exit(1)
+	}
+	<-done
+}
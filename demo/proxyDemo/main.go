@@ -1,18 +1,19 @@
 package main
 
 import (
+	"flag"
 	"net"
 	"log"
 	"bufio"
 	"fmt"
 	"io"
-	"github.com/garyburd/redigo/redis"
+	"redisProxy/redis"
 	"reflect"
 	"bytes"
-	"strconv"
 	"strings"
 	"runtime"
 	"encoding/gob"
+	"redisProxy/proxy"
 )
 
 type protocolError string
@@ -25,7 +26,7 @@ type redisClient struct {
 	conn		net.Conn
 	tcpServer	*tcpServer
 	reader		*bufio.Reader
-	writer		*bufio.Writer
+	writer		*proxy.Writer	// 按redis回复的原始类型编码
 	bufferSize	int
 }
 
@@ -287,45 +288,6 @@ func convertInterfaceToString(key interface{}) (string, error) {
 //	return res, nil
 //}
 
-/*
-	处理发送给redis-cli的返回信息(长度大于1024，需要分包处理)
- */
-func parseMessage(message []byte) ([]byte, error) {
-	length := len(message)
-	charset := []byte("\r\n")
-	if length > 1024 {
-		// 处理长度大于1024字节的数据
-		packageNum := (length / 1024) + 1	// 消息包长度
-		result := make([][]byte, (packageNum+1)*2)	// 消息总量
-		result[0] = []byte("*"+strconv.Itoa(packageNum)) //消息头
-		tmp := packageNum	// 数组下标计数器
-		counter := 1	// 消息长度计数器
-		for i := 0; i < length; i += 1024{
-			if counter == packageNum {
-				result[packageNum-tmp+1] = []byte("$"+strconv.Itoa(length - 1024*(packageNum-1)))	// 单个包的字节长度
-				result[packageNum-tmp+2] = message[i:i+length-1024*(packageNum-1)]	// 单个包的内容（末尾小于等于1024字节）
-			} else {
-				result[packageNum-tmp+1] = []byte("$"+strconv.Itoa(1024))	// 单个包的字节长度
-				result[packageNum-tmp+2] = message[i:i+1024]	// 单个包的内容（1024字节）
-			}
-			tmp -= 2
-			counter += 1
-		}
-		result[packageNum*2 + 1] = []byte{}	// 消息尾
-		return bytes.Join(result, charset), nil	// 消息内容之间加上 /r/n 格式化成[]byte类型数据
-	}else {
-		// 直接发送单个包
-		result := [][]byte{
-			[]byte("*1"),
-			[]byte("$" + strconv.Itoa(len(message))),
-			message,
-			[]byte(""),
-		}
-		res := bytes.Join(result, charset)
-		return res, nil
-	}
-}
-
 /*
 	过滤命令部分
  */
@@ -411,6 +373,17 @@ func (tcpServer *tcpServer) Listen() {
 	}
 	defer listener.Close()
 
+	// 所有redis-cli客户端共享后端连接池
+	pool := &redis.Pool{
+		MaxIdle: 16,
+		MaxActive: 256,
+		Wait: true,
+		Dial: func() (redis.Conn, error) {
+			return redis.Dial("tcp", *backendAddress, redis.DialPassword(*backendPassword))
+		},
+	}
+	defer pool.Close()
+
 	// for循环监听多组redis-cli客户端
 	for {
 		conn, _ := listener.Accept()
@@ -418,14 +391,12 @@ func (tcpServer *tcpServer) Listen() {
 			conn:conn,
 			tcpServer:tcpServer,
 			reader:bufio.NewReader(conn),
-			writer:bufio.NewWriter(conn),
+			writer:proxy.NewWriter(conn, 1024),
 			bufferSize:1024,
 		}
 
 		//go routine异步处理多个redis-cli客户端
 		go func() {
-			c, _ := redis.Dial("tcp","xxxx",redis.DialPassword("xxxx"))
-
 			// for循环接收多组redis-cli发来的消息
 			for {
 				reply, _ := redisClient.Receive() // receive message from client reader and parse to interface{}
@@ -434,34 +405,36 @@ func (tcpServer *tcpServer) Listen() {
 					command, _ := convertInterfaceToString(message[0])
 					_, ok := filter[strings.ToUpper(command)]// 过滤命令
 					if !ok{
+						c := pool.Get()	// 每条命令从连接池借用连接，执行后归还
 						actual, err := c.Do(command, message[1:]...)
-						if actual != nil && err == nil{	// 判断执行返回结果是否为空
-							result, err := redis.Bytes(actual, nil)	// 将interface{}转成bytes类型
-							if err != nil{
-								log.Fatal(err)
-							}
-							res, _ := parseMessage(result)	//处理数据
-							redisClient.SendBytes(res)	//将处理好的数据递归发送给redis客户端
-						}else {
-							result := []byte("")
-							res, _ := parseMessage(result)
-							redisClient.SendBytes(res)
+						c.Close()
+						if err != nil {	// 错误回复原样返回
+							actual = err
 						}
+						redisClient.writer.WriteReply(actual)	//将编码好的数据发送给redis客户端
 					}else {	// 不支持命令
-						result := []byte("ERR command not support")
-						res, _ := parseMessage(result)
-						redisClient.SendBytes(res)
+						redisClient.writer.WriteError("ERR command '" + strings.ToUpper(command) + "' is not allowed by proxy")
 					}
+					redisClient.writer.Flush()
 				}
 			}
 		} ()
 	}
 }
 
-const address  = "xxxx"
+/*
+	代理地址与后端redis配置通过命令行参数传入
+ */
+var (
+	address		= flag.String("listen", ":6380", "proxy listen address")
+	backendAddress	= flag.String("backend", "127.0.0.1:6379", "redis backend address")
+	backendPassword	= flag.String("password", "", "redis backend password")
+)
+
 func main(){
+	flag.Parse()
 	nCpu := runtime.NumCPU()
 	runtime.GOMAXPROCS(nCpu)
-	tcpServer := New(address)
+	tcpServer := New(*address)
 	defer tcpServer.Listen()
 }
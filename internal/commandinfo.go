@@ -0,0 +1,194 @@
+package internal
+
+import (
+	"strings"
+)
+
+/*
+	连接状态：事务、监听、订阅等会改变连接状态的命令
+	SelectState不会被清除，执行过SELECT的连接不再放回连接池
+ */
+const (
+	WatchState = 1 << iota
+	MultiState
+	SubscribeState
+	MonitorState
+	SelectState
+)
+
+/*
+	命令读写分类：ReadFlag为只读命令，可以发往从库；WriteFlag为写命令
+	两者都没有的命令(PING、INFO等)不影响读写分离
+	KeylessFlag为确定不访问key的命令，既没有key位置又不是KeylessFlag的命令可能访问任意key
+ */
+const (
+	ReadFlag = 1 << iota
+	WriteFlag
+	KeylessFlag
+)
+
+/*
+	命令信息
+	Set/Clear: 命令对连接状态的影响
+	Flags: 读写分类
+	FirstKey/LastKey/KeyStep: key在命令中的位置，与redis命令表一致，
+	命令名的位置为0，LastKey为负数时从末尾倒数，FirstKey为0表示没有key
+ */
+type CommandInfo struct {
+	Set, Clear int
+
+	Flags int
+
+	FirstKey, LastKey, KeyStep int
+}
+
+var commandInfos = map[string]CommandInfo{
+	"WATCH":      {Set: WatchState},
+	"UNWATCH":    {Clear: WatchState},
+	"MULTI":      {Set: MultiState},
+	"EXEC":       {Clear: WatchState | MultiState},
+	"DISCARD":    {Clear: WatchState | MultiState},
+	"PSUBSCRIBE": {Set: SubscribeState},
+	"SUBSCRIBE":  {Set: SubscribeState},
+	"MONITOR":    {Set: MonitorState},
+	"SELECT":     {Set: SelectState},
+}
+
+/*
+	key位置: {FirstKey, LastKey, KeyStep}
+ */
+var commandKeys = map[string][3]int{
+	// keys
+	"DEL": {1, -1, 1}, "UNLINK": {1, -1, 1}, "EXISTS": {1, -1, 1}, "TOUCH": {1, -1, 1},
+	"EXPIRE": {1, 1, 1}, "EXPIREAT": {1, 1, 1}, "PEXPIRE": {1, 1, 1}, "PEXPIREAT": {1, 1, 1},
+	"TTL": {1, 1, 1}, "PTTL": {1, 1, 1}, "PERSIST": {1, 1, 1}, "TYPE": {1, 1, 1},
+	"RENAME": {1, 2, 1}, "RENAMENX": {1, 2, 1}, "SORT": {1, 1, 1}, "DUMP": {1, 1, 1},
+	"RESTORE": {1, 1, 1}, "OBJECT": {2, 2, 1}, "MOVE": {1, 1, 1}, "COPY": {1, 2, 1},
+	"EXPIRETIME": {1, 1, 1}, "PEXPIRETIME": {1, 1, 1}, "SORT_RO": {1, 1, 1},
+	// strings
+	"GET": {1, 1, 1}, "SET": {1, 1, 1}, "SETNX": {1, 1, 1}, "SETEX": {1, 1, 1},
+	"GETDEL": {1, 1, 1}, "GETEX": {1, 1, 1}, "LCS": {1, 2, 1}, "BITFIELD_RO": {1, 1, 1},
+	"PSETEX": {1, 1, 1}, "GETSET": {1, 1, 1}, "APPEND": {1, 1, 1}, "STRLEN": {1, 1, 1},
+	"SETRANGE": {1, 1, 1}, "GETRANGE": {1, 1, 1}, "SUBSTR": {1, 1, 1},
+	"INCR": {1, 1, 1}, "DECR": {1, 1, 1}, "INCRBY": {1, 1, 1}, "DECRBY": {1, 1, 1},
+	"INCRBYFLOAT": {1, 1, 1}, "MGET": {1, -1, 1}, "MSET": {1, -1, 2}, "MSETNX": {1, -1, 2},
+	"GETBIT": {1, 1, 1}, "SETBIT": {1, 1, 1}, "BITCOUNT": {1, 1, 1}, "BITPOS": {1, 1, 1},
+	"BITFIELD": {1, 1, 1}, "BITOP": {2, -1, 1},
+	// lists
+	"LPUSH": {1, 1, 1}, "RPUSH": {1, 1, 1}, "LPUSHX": {1, 1, 1}, "RPUSHX": {1, 1, 1},
+	"LINSERT": {1, 1, 1}, "LPOP": {1, 1, 1}, "RPOP": {1, 1, 1}, "LLEN": {1, 1, 1},
+	"LINDEX": {1, 1, 1}, "LSET": {1, 1, 1}, "LRANGE": {1, 1, 1}, "LTRIM": {1, 1, 1},
+	"LREM": {1, 1, 1}, "RPOPLPUSH": {1, 2, 1}, "BLPOP": {1, -2, 1}, "BRPOP": {1, -2, 1},
+	"BRPOPLPUSH": {1, 2, 1}, "LMOVE": {1, 2, 1}, "BLMOVE": {1, 2, 1}, "LPOS": {1, 1, 1},
+	// sets
+	"SADD": {1, 1, 1}, "SREM": {1, 1, 1}, "SMOVE": {1, 2, 1}, "SISMEMBER": {1, 1, 1},
+	"SCARD": {1, 1, 1}, "SPOP": {1, 1, 1}, "SRANDMEMBER": {1, 1, 1}, "SMEMBERS": {1, 1, 1},
+	"SSCAN": {1, 1, 1}, "SINTER": {1, -1, 1}, "SINTERSTORE": {1, -1, 1}, "SUNION": {1, -1, 1},
+	"SUNIONSTORE": {1, -1, 1}, "SDIFF": {1, -1, 1}, "SDIFFSTORE": {1, -1, 1},
+	"SMISMEMBER": {1, 1, 1},
+	// sorted sets
+	"ZADD": {1, 1, 1}, "ZINCRBY": {1, 1, 1}, "ZREM": {1, 1, 1}, "ZREMRANGEBYSCORE": {1, 1, 1},
+	"ZREMRANGEBYRANK": {1, 1, 1}, "ZREMRANGEBYLEX": {1, 1, 1}, "ZRANGE": {1, 1, 1},
+	"ZRANGEBYSCORE": {1, 1, 1}, "ZREVRANGEBYSCORE": {1, 1, 1}, "ZRANGEBYLEX": {1, 1, 1},
+	"ZREVRANGEBYLEX": {1, 1, 1}, "ZREVRANGE": {1, 1, 1}, "ZCOUNT": {1, 1, 1},
+	"ZLEXCOUNT": {1, 1, 1}, "ZCARD": {1, 1, 1}, "ZSCORE": {1, 1, 1}, "ZRANK": {1, 1, 1},
+	"ZREVRANK": {1, 1, 1}, "ZSCAN": {1, 1, 1}, "ZPOPMIN": {1, 1, 1}, "ZPOPMAX": {1, 1, 1},
+	"BZPOPMIN": {1, -2, 1}, "BZPOPMAX": {1, -2, 1}, "ZRANGESTORE": {1, 2, 1},
+	"ZRANDMEMBER": {1, 1, 1}, "ZMSCORE": {1, 1, 1},
+	// hashes
+	"HSET": {1, 1, 1}, "HSETNX": {1, 1, 1}, "HGET": {1, 1, 1}, "HMSET": {1, 1, 1},
+	"HMGET": {1, 1, 1}, "HINCRBY": {1, 1, 1}, "HINCRBYFLOAT": {1, 1, 1}, "HDEL": {1, 1, 1},
+	"HLEN": {1, 1, 1}, "HSTRLEN": {1, 1, 1}, "HKEYS": {1, 1, 1}, "HVALS": {1, 1, 1},
+	"HGETALL": {1, 1, 1}, "HEXISTS": {1, 1, 1}, "HSCAN": {1, 1, 1}, "HRANDFIELD": {1, 1, 1},
+	// hyperloglog
+	"PFADD": {1, 1, 1}, "PFCOUNT": {1, -1, 1}, "PFMERGE": {1, -1, 1},
+	// geo
+	"GEOADD": {1, 1, 1}, "GEOHASH": {1, 1, 1}, "GEOPOS": {1, 1, 1}, "GEODIST": {1, 1, 1},
+	"GEORADIUS": {1, 1, 1}, "GEORADIUSBYMEMBER": {1, 1, 1}, "GEORADIUS_RO": {1, 1, 1},
+	"GEORADIUSBYMEMBER_RO": {1, 1, 1}, "GEOSEARCH": {1, 1, 1}, "GEOSEARCHSTORE": {1, 2, 1},
+	// streams
+	"XADD": {1, 1, 1}, "XRANGE": {1, 1, 1}, "XREVRANGE": {1, 1, 1}, "XLEN": {1, 1, 1},
+	"XDEL": {1, 1, 1}, "XTRIM": {1, 1, 1}, "XACK": {1, 1, 1}, "XCLAIM": {1, 1, 1},
+	"XPENDING": {1, 1, 1}, "XGROUP": {2, 2, 1}, "XINFO": {2, 2, 1}, "XAUTOCLAIM": {1, 1, 1},
+	"XSETID": {1, 1, 1},
+	// transactions
+	"WATCH": {1, -1, 1},
+}
+
+/*
+	只读命令
+ */
+var readCommands = []string{
+	"EXISTS", "TTL", "PTTL", "TYPE", "DUMP", "OBJECT", "SCAN", "RANDOMKEY",
+	"EXPIRETIME", "PEXPIRETIME", "SORT_RO",
+	"GET", "MGET", "STRLEN", "GETRANGE", "SUBSTR", "GETBIT", "BITCOUNT", "BITPOS", "LCS", "BITFIELD_RO",
+	"LLEN", "LINDEX", "LRANGE", "LPOS",
+	"SCARD", "SISMEMBER", "SMISMEMBER", "SMEMBERS", "SRANDMEMBER", "SSCAN", "SINTER", "SUNION", "SDIFF",
+	"SINTERCARD",
+	"ZRANGE", "ZRANGEBYSCORE", "ZREVRANGEBYSCORE", "ZRANGEBYLEX", "ZREVRANGEBYLEX", "ZREVRANGE",
+	"ZCOUNT", "ZLEXCOUNT", "ZCARD", "ZSCORE", "ZRANK", "ZREVRANK", "ZSCAN",
+	"ZRANDMEMBER", "ZMSCORE", "ZUNION", "ZINTER", "ZDIFF", "ZINTERCARD",
+	"HGET", "HMGET", "HLEN", "HSTRLEN", "HKEYS", "HVALS", "HGETALL", "HEXISTS", "HSCAN", "HRANDFIELD",
+	"PFCOUNT", "GEOHASH", "GEOPOS", "GEODIST", "GEORADIUS_RO", "GEORADIUSBYMEMBER_RO", "GEOSEARCH",
+	"XRANGE", "XREVRANGE", "XLEN", "XREAD", "XPENDING", "XINFO",
+}
+
+/*
+	写命令
+ */
+var writeCommands = []string{
+	"DEL", "UNLINK", "EXPIRE", "EXPIREAT", "PEXPIRE", "PEXPIREAT", "PERSIST", "RENAME", "RENAMENX",
+	"RESTORE", "MOVE", "SORT", "FLUSHDB", "FLUSHALL", "COPY",
+	"SET", "SETNX", "SETEX", "PSETEX", "GETSET", "APPEND", "SETRANGE", "INCR", "DECR", "INCRBY",
+	"DECRBY", "INCRBYFLOAT", "MSET", "MSETNX", "SETBIT", "BITFIELD", "BITOP", "GETDEL", "GETEX",
+	"LPUSH", "RPUSH", "LPUSHX", "RPUSHX", "LINSERT", "LPOP", "RPOP", "LSET", "LTRIM", "LREM",
+	"RPOPLPUSH", "BLPOP", "BRPOP", "BRPOPLPUSH", "LMOVE", "BLMOVE", "LMPOP", "BLMPOP",
+	"SADD", "SREM", "SMOVE", "SPOP", "SINTERSTORE", "SUNIONSTORE", "SDIFFSTORE",
+	"ZADD", "ZINCRBY", "ZREM", "ZREMRANGEBYSCORE", "ZREMRANGEBYRANK", "ZREMRANGEBYLEX",
+	"ZUNIONSTORE", "ZINTERSTORE", "ZDIFFSTORE", "ZRANGESTORE", "ZPOPMIN", "ZPOPMAX", "ZMPOP",
+	"BZPOPMIN", "BZPOPMAX", "BZMPOP",
+	"HSET", "HSETNX", "HMSET", "HINCRBY", "HINCRBYFLOAT", "HDEL",
+	"PFADD", "PFMERGE", "GEOADD", "GEORADIUS", "GEORADIUSBYMEMBER", "GEOSEARCHSTORE",
+	"XADD", "XDEL", "XTRIM", "XACK", "XCLAIM", "XGROUP", "XREADGROUP", "XAUTOCLAIM", "XSETID",
+	"EVAL", "EVALSHA",
+}
+
+/*
+	不访问key的命令，PUBLISH的频道不是key但按key路由，不在此列
+	KEYS、SCAN、DBSIZE、FLUSHDB等访问整个库的命令也不在此列
+ */
+var keylessCommands = []string{
+	"PING", "ECHO", "QUIT", "AUTH", "HELLO", "SELECT", "INFO", "TIME", "LASTSAVE",
+	"ROLE", "WAIT", "COMMAND", "CLIENT", "CONFIG", "SLOWLOG", "READONLY", "READWRITE",
+	"MULTI", "EXEC", "DISCARD", "UNWATCH", "SCRIPT",
+	"SUBSCRIBE", "PSUBSCRIBE", "UNSUBSCRIBE", "PUNSUBSCRIBE", "PUBSUB",
+	"PROXY",
+}
+
+func init() {
+	for flag, names := range map[int][]string{ReadFlag: readCommands, WriteFlag: writeCommands, KeylessFlag: keylessCommands} {
+		for _, n := range names {
+			ci := commandInfos[n]
+			ci.Flags |= flag
+			commandInfos[n] = ci
+		}
+	}
+	for n, keys := range commandKeys {
+		ci := commandInfos[n]
+		ci.FirstKey, ci.LastKey, ci.KeyStep = keys[0], keys[1], keys[2]
+		commandInfos[n] = ci
+	}
+	for n, ci := range commandInfos {
+		commandInfos[strings.ToLower(n)] = ci
+	}
+}
+
+/*
+	查询命令对连接状态的影响以及key的位置
+ */
+func LookupCommandInfo(commandName string) CommandInfo {
+	if ci, ok := commandInfos[commandName]; ok {
+		return ci
+	}
+	return commandInfos[strings.ToUpper(commandName)]
+}
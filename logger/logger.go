@@ -0,0 +1,210 @@
+package logger
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+/*
+	leveled structured logger
+	a line is a message and key-value fields, written as logfmt or json, e.g.
+	time=2006-01-02T15:04:05.000+08:00 level=warn msg="backend connection failed" client_id=7 err=EOF
+	{"time":"2006-01-02T15:04:05.000+08:00","level":"warn","msg":"backend connection failed","client_id":7,"err":"EOF"}
+	a nil *Logger discards everything, so that components work without one
+ */
+
+var nowFunc = time.Now // for testing
+
+const timeFormat = "2006-01-02T15:04:05.000Z07:00"
+
+type Level int
+
+const (
+	DebugLevel Level = iota
+	InfoLevel
+	WarnLevel
+	ErrorLevel
+)
+
+var levelNames = []string{"debug", "info", "warn", "error"}
+
+func (l Level) String() string {
+	if l < DebugLevel || l > ErrorLevel {
+		return fmt.Sprintf("level(%d)", int(l))
+	}
+	return levelNames[l]
+}
+
+/*
+	level by name, empty means info
+ */
+func ParseLevel(s string) (Level, error) {
+	if s == "" {
+		return InfoLevel, nil
+	}
+	for i, name := range levelNames {
+		if strings.EqualFold(s, name) {
+			return Level(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown log level %q, want debug, info, warn or error", s)
+}
+
+/*
+	writer shared by a logger and the loggers derived from it by With
+ */
+type output struct {
+	mu	sync.Mutex
+	w	io.Writer
+	json	bool
+	level	Level
+}
+
+type Logger struct {
+	out	*output
+	fields	[]interface{}	// key-value pairs added by With
+}
+
+/*
+	logger writing lines of level and above to w, json or logfmt
+ */
+func New(w io.Writer, json bool, level Level) *Logger {
+	return &Logger{out: &output{w: w, json: json, level: level}}
+}
+
+/*
+	logger adding key-value pairs to every line
+ */
+func (l *Logger) With(keyvals ...interface{}) *Logger {
+	if l == nil {
+		return nil
+	}
+	fields := make([]interface{}, 0, len(l.fields)+len(keyvals))
+	fields = append(append(fields, l.fields...), keyvals...)
+	return &Logger{out: l.out, fields: fields}
+}
+
+/*
+	whether lines of level are written, to skip building expensive fields
+ */
+func (l *Logger) Enabled(level Level) bool {
+	return l != nil && level >= l.out.level
+}
+
+func (l *Logger) Debug(msg string, keyvals ...interface{}) {
+	l.log(DebugLevel, msg, keyvals)
+}
+
+func (l *Logger) Info(msg string, keyvals ...interface{}) {
+	l.log(InfoLevel, msg, keyvals)
+}
+
+func (l *Logger) Warn(msg string, keyvals ...interface{}) {
+	l.log(WarnLevel, msg, keyvals)
+}
+
+func (l *Logger) Error(msg string, keyvals ...interface{}) {
+	l.log(ErrorLevel, msg, keyvals)
+}
+
+func (l *Logger) log(level Level, msg string, keyvals []interface{}) {
+	if !l.Enabled(level) {
+		return
+	}
+	var b bytes.Buffer
+	kv := []interface{}{"time", nowFunc().Format(timeFormat), "level", level.String(), "msg", msg}
+	kv = append(append(kv, l.fields...), keyvals...)
+	if len(kv)%2 != 0 {
+		kv = append(kv, "(MISSING)")
+	}
+	if l.out.json {
+		writeJSON(&b, kv)
+	} else {
+		writeLogfmt(&b, kv)
+	}
+	b.WriteByte('\n')
+	l.out.mu.Lock()
+	defer l.out.mu.Unlock()
+	// 日志写入失败无处可报，忽略
+	l.out.w.Write(b.Bytes())
+}
+
+func writeLogfmt(b *bytes.Buffer, kv []interface{}) {
+	for i := 0; i < len(kv); i += 2 {
+		if i > 0 {
+			b.WriteByte(' ')
+		}
+		b.WriteString(logfmtValue(fmt.Sprint(kv[i])))
+		b.WriteByte('=')
+		b.WriteString(logfmtValue(text(kv[i+1])))
+	}
+}
+
+/*
+	values with spaces, quotes, '=' or control characters are quoted
+ */
+func logfmtValue(s string) string {
+	if s == "" {
+		return `""`
+	}
+	for _, r := range s {
+		if r <= ' ' || r == '=' || r == '"' || r == unicode.ReplacementChar || !unicode.IsPrint(r) {
+			return strconv.Quote(s)
+		}
+	}
+	return s
+}
+
+func writeJSON(b *bytes.Buffer, kv []interface{}) {
+	b.WriteByte('{')
+	for i := 0; i < len(kv); i += 2 {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		key, _ := json.Marshal(fmt.Sprint(kv[i]))
+		b.Write(key)
+		b.WriteByte(':')
+		b.Write(jsonValue(kv[i+1]))
+	}
+	b.WriteByte('}')
+}
+
+/*
+	numbers, booleans and nil as json values, everything else as a string
+ */
+func jsonValue(v interface{}) []byte {
+	switch v.(type) {
+	case nil, bool, int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64:
+		if data, err := json.Marshal(v); err == nil {
+			return data
+		}
+	}
+	data, _ := json.Marshal(text(v))
+	return data
+}
+
+/*
+	text of a value, errors and durations by their String
+ */
+func text(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	case error:
+		return v.Error()
+	case fmt.Stringer:
+		return v.String()
+	case nil:
+		return ""
+	}
+	return fmt.Sprint(v)
+}
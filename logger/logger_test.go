@@ -0,0 +1,54 @@
+package logger
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+)
+
+func init() {
+	nowFunc = func() time.Time { return time.Date(2024, 1, 2, 15, 4, 5, 0, time.UTC) }
+}
+
+func TestLogger_logfmt(t *testing.T) {
+	var buf bytes.Buffer
+	l := New(&buf, false, InfoLevel).With("client_id", 7, "remote_addr", "10.0.0.1:5000")
+	l.Debug("client connected")
+	l.Warn("backend connection failed", "backend", "10.0.0.2:6379", "err", errors.New("read: connection reset"), "delay", 5*time.Millisecond, "odd")
+	expected := `time=2024-01-02T15:04:05.000Z level=warn msg="backend connection failed" client_id=7 remote_addr=10.0.0.1:5000 ` +
+		`backend=10.0.0.2:6379 err="read: connection reset" delay=5ms odd=(MISSING)` + "\n"
+	if buf.String() != expected {
+		t.Errorf("logfmt line = %q, want %q", buf.String(), expected)
+	}
+}
+
+func TestLogger_json(t *testing.T) {
+	var buf bytes.Buffer
+	l := New(&buf, true, DebugLevel)
+	l.Debug("slow command", "total", 12*time.Millisecond, "ok", true, "n", int64(3), "command", "GET \"a\"", "none", nil)
+	expected := `{"time":"2024-01-02T15:04:05.000Z","level":"debug","msg":"slow command",` +
+		`"total":"12ms","ok":true,"n":3,"command":"GET \"a\"","none":null}` + "\n"
+	if buf.String() != expected {
+		t.Errorf("json line = %q, want %q", buf.String(), expected)
+	}
+}
+
+func TestLogger_nil(t *testing.T) {
+	var l *Logger
+	l.With("a", 1).Error("discarded")
+	if l.Enabled(ErrorLevel) {
+		t.Errorf("nil logger is enabled")
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	for s, level := range map[string]Level{"": InfoLevel, "debug": DebugLevel, "WARN": WarnLevel, "error": ErrorLevel} {
+		if l, err := ParseLevel(s); err != nil || l != level {
+			t.Errorf("ParseLevel(%q) = %v, %v, want %v", s, l, err, level)
+		}
+	}
+	if _, err := ParseLevel("verbose"); err == nil {
+		t.Errorf("ParseLevel(verbose) did not return an error")
+	}
+}
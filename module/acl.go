@@ -0,0 +1,294 @@
+package module
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"strings"
+	"redisProxy/internal"
+	"redisProxy/proxy"
+	"redisProxy/redis"
+)
+
+/*
+	proxy-side authentication and ACLs, independent of the backend password
+	clients authenticate with AUTH password (user "default") or AUTH user password,
+	a user runs the commands its rules allow on the keys matching its patterns
+ */
+
+const defaultUser = "default"
+
+/*
+	command categories of ACL rules, "@all" matches every command
+ */
+var aclCategories = map[string]func(name string) bool{
+	"read": func(name string) bool {
+		return internal.LookupCommandInfo(name).Flags&internal.ReadFlag != 0
+	},
+	"write": func(name string) bool {
+		return internal.LookupCommandInfo(name).Flags&internal.WriteFlag != 0
+	},
+	"pubsub": oneOf("SUBSCRIBE", "PSUBSCRIBE", "UNSUBSCRIBE", "PUNSUBSCRIBE", "PUBLISH", "PUBSUB"),
+	"transaction": oneOf("MULTI", "EXEC", "DISCARD", "WATCH", "UNWATCH"),
+	"scripting": oneOf("EVAL", "EVALSHA", "SCRIPT"),
+	"blocking": func(name string) bool {
+		_, ok := blockingCommands[name]
+		return ok
+	},
+	"connection": oneOf("AUTH", "PING", "ECHO", "SELECT", "QUIT"),
+	"admin": oneOf("PROXY"),
+}
+
+func oneOf(names ...string) func(name string) bool {
+	set := make(map[string]bool, len(names))
+	for _, name := range names {
+		set[name] = true
+	}
+	return func(name string) bool {
+		return set[name]
+	}
+}
+
+type acl struct {
+	users	map[string]*aclUser
+}
+
+/*
+	rules are applied in order, the last rule matching a command decides
+ */
+type aclUser struct {
+	name		string
+	password	string
+	rules		[]aclRule
+	keys		[]string
+}
+
+type aclRule struct {
+	allow		bool
+	command		string
+	category	func(name string) bool
+}
+
+/*
+	build the ACL from the users config, nil when clients need not authenticate
+ */
+func newACL(users []UserConfig) (*acl, error) {
+	if len(users) == 0 {
+		return nil, nil
+	}
+	a := &acl{users: make(map[string]*aclUser)}
+	for i, config := range users {
+		if config.Name == "" {
+			return nil, &ConfigError{Key: fmt.Sprintf("[%d].name", i), Err: fmt.Errorf("required")}
+		}
+		if _, ok := a.users[config.Name]; ok {
+			return nil, &ConfigError{Key: fmt.Sprintf("[%d].name", i), Err: fmt.Errorf("duplicate user %q", config.Name)}
+		}
+		if config.Password == "" {
+			return nil, &ConfigError{Key: fmt.Sprintf("[%d].password", i), Err: fmt.Errorf("required")}
+		}
+		user := &aclUser{name: config.Name, password: config.Password, keys: config.Keys}
+		for j, rule := range config.Commands {
+			r, err := parseACLRule(rule)
+			if err != nil {
+				return nil, &ConfigError{Key: fmt.Sprintf("[%d].commands[%d]", i, j), Err: err}
+			}
+			user.rules = append(user.rules, r)
+		}
+		a.users[config.Name] = user
+	}
+	return a, nil
+}
+
+/*
+	"+@read", "-FLUSHDB", a rule without + or - allows
+ */
+func parseACLRule(rule string) (aclRule, error) {
+	r := aclRule{allow: true}
+	name := strings.TrimSpace(rule)
+	if strings.HasPrefix(name, "+") || strings.HasPrefix(name, "-") {
+		r.allow = name[0] == '+'
+		name = name[1:]
+	}
+	if strings.HasPrefix(name, "@") {
+		category := strings.ToLower(name[1:])
+		if category == "all" {
+			r.category = func(string) bool { return true }
+		} else if r.category = aclCategories[category]; r.category == nil {
+			return r, fmt.Errorf("unknown command category %q", name)
+		}
+		return r, nil
+	}
+	if name == "" || strings.ContainsAny(name, " \t") {
+		return r, fmt.Errorf("bad command rule %q, want \"+COMMAND\" or \"-@category\"", rule)
+	}
+	r.command = strings.ToUpper(name)
+	return r, nil
+}
+
+/*
+	AUTH password or AUTH user password, a failed AUTH keeps the current user
+ */
+func (a *acl) auth(client *Client, command *proxy.Command) interface{} {
+	var name, password string
+	switch len(command.Args) {
+	case 1:
+		name, password = defaultUser, string(command.Args[0])
+	case 2:
+		name, password = string(command.Args[0]), string(command.Args[1])
+	default:
+		return redis.Error("ERR wrong number of arguments for 'auth' command")
+	}
+	user, ok := a.users[name]
+	if !ok || subtle.ConstantTimeCompare([]byte(user.password), []byte(password)) != 1 {
+		return redis.Error("WRONGPASS invalid username-password pair")
+	}
+	client.user = user
+	return "OK"
+}
+
+/*
+	check that the client may run the command, nil ACL allows everything
+ */
+func (a *acl) check(client *Client, command *proxy.Command) error {
+	if a == nil {
+		return nil
+	}
+	name := command.CommandName()
+	// 按用户名查找，重新加载的规则对已认证的连接立即生效
+	var user *aclUser
+	if client.user != nil {
+		user = a.users[client.user.name]
+	}
+	if user == nil {
+		if name == "AUTH" || name == "QUIT" {
+			return nil
+		}
+		return redis.Error("NOAUTH Authentication required.")
+	}
+	if !user.allowed(name) {
+		return redis.Error(fmt.Sprintf("NOPERM this user has no permissions to run the '%s' command or its subcommand", strings.ToLower(name)))
+	}
+	// PUBLISH的频道不是key
+	if aclCategories["pubsub"](name) {
+		return nil
+	}
+	// 不知道访问哪些key的命令，对有key限制的用户拒绝执行
+	if len(user.keys) > 0 && !knownKeys(command) {
+		return redis.Error("NOPERM this user has no permissions to access one of the keys used as arguments")
+	}
+	for _, key := range commandKeys(command) {
+		if !user.accessible(string(key)) {
+			return redis.Error("NOPERM this user has no permissions to access one of the keys used as arguments")
+		}
+	}
+	return nil
+}
+
+func (user *aclUser) allowed(name string) bool {
+	if name == "AUTH" || name == "QUIT" {
+		return true
+	}
+	allowed := false
+	for _, r := range user.rules {
+		if r.command == name || (r.category != nil && r.category(name)) {
+			allowed = r.allow
+		}
+	}
+	return allowed
+}
+
+/*
+	keys the user may access, no patterns means all keys
+ */
+func (user *aclUser) accessible(key string) bool {
+	if len(user.keys) == 0 {
+		return true
+	}
+	for _, pattern := range user.keys {
+		if globMatch(pattern, key) {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+	redis glob-style match: * ? [abc] [^a-z] and \ escapes
+ */
+func globMatch(pattern, s string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for len(pattern) > 1 && pattern[1] == '*' {
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 1 {
+				return true
+			}
+			for i := 0; i <= len(s); i++ {
+				if globMatch(pattern[1:], s[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if len(s) == 0 {
+				return false
+			}
+			s = s[1:]
+			pattern = pattern[1:]
+		case '[':
+			if len(s) == 0 {
+				return false
+			}
+			end := strings.IndexByte(pattern[1:], ']')
+			if end < 0 {
+				// 没有闭合的[按普通字符处理
+				if s[0] != '[' {
+					return false
+				}
+				s, pattern = s[1:], pattern[1:]
+				continue
+			}
+			class := pattern[1 : end+1]
+			if !classMatch(class, s[0]) {
+				return false
+			}
+			s, pattern = s[1:], pattern[end+2:]
+		case '\\':
+			if len(pattern) > 1 {
+				pattern = pattern[1:]
+			}
+			fallthrough
+		default:
+			if len(s) == 0 || s[0] != pattern[0] {
+				return false
+			}
+			s, pattern = s[1:], pattern[1:]
+		}
+	}
+	return len(s) == 0
+}
+
+func classMatch(class string, c byte) bool {
+	negate := len(class) > 0 && class[0] == '^'
+	if negate {
+		class = class[1:]
+	}
+	match := false
+	for i := 0; i < len(class); i++ {
+		if i+2 < len(class) && class[i+1] == '-' {
+			lo, hi := class[i], class[i+2]
+			if lo > hi {
+				lo, hi = hi, lo
+			}
+			if c >= lo && c <= hi {
+				match = true
+			}
+			i += 2
+		} else if class[i] == c {
+			match = true
+		}
+	}
+	return match != negate
+}
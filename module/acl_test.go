@@ -0,0 +1,145 @@
+package module
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+	"testing"
+	"redisProxy/proxy"
+	"redisProxy/redis"
+)
+
+var globTests = []struct {
+	pattern, s string
+	match      bool
+}{
+	{"*", "", true},
+	{"app:*", "app:1", true},
+	{"app:*", "web:1", false},
+	{"a/*", "a/b/c", true},
+	{"h?llo", "hello", true},
+	{"h?llo", "hllo", false},
+	{"h[ae]llo", "hallo", true},
+	{"h[^e]llo", "hello", false},
+	{"h[a-c]llo", "hbllo", true},
+	{"*:[0-9]", "user:7", true},
+	{"a\\*", "a*", true},
+	{"a\\*", "ab", false},
+	{"[abc", "[abc", true},
+}
+
+func TestGlobMatch(t *testing.T) {
+	for _, tt := range globTests {
+		if match := globMatch(tt.pattern, tt.s); match != tt.match {
+			t.Errorf("globMatch(%q, %q) = %v, want %v", tt.pattern, tt.s, match, tt.match)
+		}
+	}
+}
+
+var testUsers = []UserConfig{
+	{Name: "default", Password: "secret", Commands: []string{"+@all", "-FLUSHDB"}},
+	{Name: "app", Password: "pw", Commands: []string{"+@read", "+@write", "-del", "+ping"}, Keys: []string{"app:*"}},
+	{Name: "scoped", Password: "pw", Commands: []string{"+@all"}, Keys: []string{"app:*"}},
+}
+
+var aclTests = []struct {
+	user    string
+	command *proxy.Command
+	err     string
+}{
+	{"", newCommand("GET", "a"), "NOAUTH Authentication required."},
+	{"", newCommand("AUTH", "secret"), ""},
+	{"default", newCommand("KEYS", "*"), ""},
+	{"default", newCommand("flushdb"), "NOPERM this user has no permissions to run the 'flushdb' command or its subcommand"},
+	{"app", newCommand("GET", "app:1"), ""},
+	{"app", newCommand("MSET", "app:1", "x", "app:2", "y"), ""},
+	{"app", newCommand("MSET", "app:1", "x", "web:2", "y"), "NOPERM this user has no permissions to access one of the keys used as arguments"},
+	{"app", newCommand("DEL", "app:1"), "NOPERM this user has no permissions to run the 'del' command or its subcommand"},
+	{"app", newCommand("PING"), ""},
+	{"app", newCommand("CONFIG", "GET", "maxmemory"), "NOPERM this user has no permissions to run the 'config' command or its subcommand"},
+	{"app", newCommand("AUTH", "default", "secret"), ""},
+	// 目标key也要检查
+	{"scoped", newCommand("SORT", "app:l", "BY", "app:w_*", "LIMIT", "0", "10", "STORE", "secret:x"), "NOPERM this user has no permissions to access one of the keys used as arguments"},
+	{"scoped", newCommand("SORT", "app:l", "GET", "#", "STORE", "app:x"), ""},
+	{"scoped", newCommand("GEORADIUS", "app:g", "0", "0", "1", "km", "STORE", "secret:y"), "NOPERM this user has no permissions to access one of the keys used as arguments"},
+	{"scoped", newCommand("GEORADIUS", "app:g", "0", "0", "1", "km", "COUNT", "5", "ANY", "STOREDIST", "secret:y"), "NOPERM this user has no permissions to access one of the keys used as arguments"},
+	{"scoped", newCommand("GEORADIUSBYMEMBER", "app:g", "m", "1", "km", "STORE", "secret:y"), "NOPERM this user has no permissions to access one of the keys used as arguments"},
+	{"scoped", newCommand("GETDEL", "secret:a"), "NOPERM this user has no permissions to access one of the keys used as arguments"},
+	{"scoped", newCommand("COPY", "app:a", "secret:b"), "NOPERM this user has no permissions to access one of the keys used as arguments"},
+	{"scoped", newCommand("LMOVE", "app:a", "secret:b", "LEFT", "RIGHT"), "NOPERM this user has no permissions to access one of the keys used as arguments"},
+	{"scoped", newCommand("ZUNION", "2", "app:a", "secret:b"), "NOPERM this user has no permissions to access one of the keys used as arguments"},
+	{"scoped", newCommand("LMOVE", "app:a", "app:b", "LEFT", "RIGHT"), ""},
+	{"scoped", newCommand("PING"), ""},
+	// 不知道key位置的命令拒绝执行
+	{"scoped", newCommand("NEWCMD", "secret:a"), "NOPERM this user has no permissions to access one of the keys used as arguments"},
+	{"scoped", newCommand("KEYS", "*"), "NOPERM this user has no permissions to access one of the keys used as arguments"},
+	{"default", newCommand("NEWCMD", "secret:a"), ""},
+}
+
+func TestACL_check(t *testing.T) {
+	a, err := newACL(testUsers)
+	if err != nil {
+		t.Fatalf("newACL() returned error %v", err)
+	}
+	for _, tt := range aclTests {
+		client := &Client{user: a.users[tt.user]}
+		err := a.check(client, tt.command)
+		if tt.err == "" && err != nil {
+			t.Errorf("%s: check(%s %q) returned error %v", tt.user, tt.command.Name, tt.command.Args, err)
+		} else if tt.err != "" && (err == nil || err.Error() != tt.err) {
+			t.Errorf("%s: check(%s %q) = %v, want %s", tt.user, tt.command.Name, tt.command.Args, err, tt.err)
+		}
+	}
+}
+
+func TestACL_configError(t *testing.T) {
+	for _, users := range [][]UserConfig{
+		{{Password: "pw"}},
+		{{Name: "a"}},
+		{{Name: "a", Password: "pw"}, {Name: "a", Password: "pw"}},
+		{{Name: "a", Password: "pw", Commands: []string{"+@dangerous"}}},
+		{{Name: "a", Password: "pw", Commands: []string{"-"}}},
+	} {
+		if _, err := newACL(users); err == nil {
+			t.Errorf("newACL(%v) did not return expected error", users)
+		}
+	}
+}
+
+func TestServer_pipelineAuth(t *testing.T) {
+	var buf bytes.Buffer
+	client := &Client{
+		reader: bufio.NewReader(strings.NewReader("GET app:1\r\nAUTH app wrong\r\nAUTH app pw\r\nGET app:1\r\nGET web:1\r\nAUTH secret\r\nGET web:1\r\n")),
+		writer: proxy.NewWriter(&buf, 4096),
+	}
+	f, _ := newFilter(defaultFilterConfig())
+	a, _ := newACL(testUsers)
+	c := &echoConn{}
+	server := &Server{
+		filter: f,
+		acl: a,
+		router: &singleRouter{pool: &redis.Pool{Dial: func() (redis.Conn, error) { return c, nil }}},
+	}
+
+	commands, err := client.readPipeline()
+	if err != nil {
+		t.Fatalf("readPipeline() returned error %v", err)
+	}
+	if err := server.pipeline(client, commands); err != nil {
+		t.Fatalf("pipeline() returned error %v", err)
+	}
+	// AUTH不会发往后端
+	if strings.Join(c.sent, " ") != "GET GET" {
+		t.Errorf("sent %v, want [GET GET]", c.sent)
+	}
+	expected := "-NOAUTH Authentication required.\r\n" +
+		"-WRONGPASS invalid username-password pair\r\n" +
+		"+OK\r\n" +
+		"$3\r\nGET\r\n" +
+		"-NOPERM this user has no permissions to access one of the keys used as arguments\r\n" +
+		"+OK\r\n" +
+		"$3\r\nGET\r\n"
+	if buf.String() != expected {
+		t.Errorf("pipeline() wrote %q, want %q", buf.String(), expected)
+	}
+}
@@ -0,0 +1,394 @@
+package module
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"redisProxy/proxy"
+	"redisProxy/redis"
+)
+
+/*
+	PROXY admin commands, answered by the proxy itself on the client listener
+	they run outside the config lock as some of them reload the config
+ */
+
+/*
+	min and max arguments of each subcommand, the subcommand included
+ */
+var adminCommands = map[string][2]int{
+	"HELP":          {1, 1},
+	"INFO":          {1, 1},
+	"POOLS":         {1, 1},
+	"CLIENTS":       {1, 1},
+	"CONFIG GET":    {3, 3},
+	"CONFIG SET":    {4, 4},
+	"FILTER RELOAD": {2, 2},
+	"RELOAD":        {1, 1},
+	"SLOWLOG GET":   {2, 3},
+	"SLOWLOG LEN":   {2, 2},
+	"SLOWLOG RESET": {2, 2},
+}
+
+var adminHelp = []string{
+	"PROXY <subcommand> [<arg> ...]. Subcommands are:",
+	"INFO",
+	"    Proxy, client, command and backend statistics.",
+	"POOLS",
+	"    Connection stats of every backend pool.",
+	"CLIENTS",
+	"    Client connections of the proxy.",
+	"CONFIG GET <pattern>",
+	"    Config keys matching the glob pattern, passwords are masked.",
+	"CONFIG SET <key> <value>",
+	"    Set a config key and reload, until the next reload of the config file.",
+	"FILTER RELOAD",
+	"    Reload the command filter from the config file.",
+	"RELOAD",
+	"    Reload the config file.",
+	"SLOWLOG GET [<count>]",
+	"    The count newest slow commands, 10 by default, -1 for all.",
+	"SLOWLOG LEN",
+	"    Number of entries of the slow log.",
+	"SLOWLOG RESET",
+	"    Clear the slow log.",
+}
+
+/*
+	subcommands changing the proxy, see AdminConfig
+ */
+var adminWrites = map[string]bool{
+	"CONFIG SET":    true,
+	"FILTER RELOAD": true,
+	"RELOAD":        true,
+	"SLOWLOG RESET": true,
+}
+
+const maskedSecret = "******"
+
+func (server *Server) admin(client *Client, command *proxy.Command) error {
+	if err := server.check(client, command); err != nil {
+		return client.write(err)
+	}
+	return client.write(server.adminReply(command))
+}
+
+/*
+	whether the subcommands changing the proxy are allowed,
+	with users configured server.check has already required the @admin category
+ */
+func (server *Server) adminEnabled() bool {
+	server.configMu.RLock()
+	defer server.configMu.RUnlock()
+	return server.acl != nil || server.config.Admin.Enabled
+}
+
+func (server *Server) adminReply(command *proxy.Command) interface{} {
+	args := command.Args
+	if len(args) == 0 {
+		return redis.Error("ERR wrong number of arguments for 'proxy' command")
+	}
+	sub := strings.ToUpper(string(args[0]))
+	if _, ok := adminCommands[sub]; !ok && len(args) > 1 {
+		sub += " " + strings.ToUpper(string(args[1]))
+	}
+	arity, ok := adminCommands[sub]
+	if !ok {
+		return redis.Error(fmt.Sprintf("ERR unknown subcommand '%s'. Try PROXY HELP.", args[0]))
+	}
+	if len(args) < arity[0] || len(args) > arity[1] {
+		return redis.Error(fmt.Sprintf("ERR wrong number of arguments for 'proxy %s' command", strings.ToLower(sub)))
+	}
+	if adminWrites[sub] && !server.adminEnabled() {
+		return redis.Error(fmt.Sprintf("NOPERM 'proxy %s' requires users with the @admin category or admin.enabled", strings.ToLower(sub)))
+	}
+	var err error
+	switch sub {
+	case "HELP":
+		lines := make([]interface{}, len(adminHelp))
+		for i, line := range adminHelp {
+			lines[i] = line
+		}
+		return lines
+	case "INFO":
+		return server.info()
+	case "POOLS":
+		return server.pools()
+	case "CLIENTS":
+		return server.clientList()
+	case "CONFIG GET":
+		var reply []interface{}
+		if reply, err = server.configGet(string(args[2])); err == nil {
+			return reply
+		}
+	case "CONFIG SET":
+		err = server.configSet(string(args[2]), string(args[3]))
+	case "FILTER RELOAD":
+		err = server.reloadFilter()
+	case "RELOAD":
+		err = server.ReloadFile()
+	case "SLOWLOG GET":
+		n := 10
+		if len(args) == 3 {
+			if n, err = strconv.Atoi(string(args[2])); err != nil {
+				return redis.Error("ERR value is out of range or not an integer")
+			}
+		}
+		return slowlogReply(server.slowlog.get(n))
+	case "SLOWLOG LEN":
+		return int64(server.slowlog.len())
+	case "SLOWLOG RESET":
+		server.slowlog.reset()
+	}
+	if err != nil {
+		return redis.Error("ERR proxy: " + err.Error())
+	}
+	return "OK"
+}
+
+/*
+	INFO-like sections of the proxy state
+ */
+func (server *Server) info() []byte {
+	server.configMu.RLock()
+	mode := server.config.Backend.Mode
+	server.configMu.RUnlock()
+	if mode == "" {
+		mode = backendModeSingle
+	}
+	server.mu.Lock()
+	var active int
+	for _, a := range server.clients {
+		if a {
+			active++
+		}
+	}
+	connected := len(server.clients)
+	server.mu.Unlock()
+	commands, filtered, accepted, protocolErrors := server.metrics.totals()
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "# Proxy\r\n")
+	fmt.Fprintf(&b, "listen:%s\r\n", server.address)
+	fmt.Fprintf(&b, "backend_mode:%s\r\n", mode)
+	fmt.Fprintf(&b, "uptime_in_seconds:%d\r\n", int64(time.Since(server.started).Seconds()))
+	fmt.Fprintf(&b, "\r\n# Clients\r\n")
+	fmt.Fprintf(&b, "connected_clients:%d\r\n", connected)
+	fmt.Fprintf(&b, "active_clients:%d\r\n", active)
+	fmt.Fprintf(&b, "\r\n# Stats\r\n")
+	fmt.Fprintf(&b, "total_connections_received:%d\r\n", accepted)
+	fmt.Fprintf(&b, "total_commands_processed:%d\r\n", commands)
+	fmt.Fprintf(&b, "filtered_commands:%d\r\n", filtered)
+	fmt.Fprintf(&b, "protocol_errors:%d\r\n", protocolErrors)
+	fmt.Fprintf(&b, "\r\n# Backends\r\n")
+	for i, backend := range server.backendStats() {
+		fmt.Fprintf(&b, "backend%d:address=%s,role=%s,active=%d,idle=%d\r\n",
+			i, backend.address, backend.role, backend.stats.ActiveCount, backend.stats.IdleCount)
+	}
+	return b.Bytes()
+}
+
+/*
+	one field-value array per backend pool, strings are written as bulk strings
+ */
+func (server *Server) pools() []interface{} {
+	reply := []interface{}{}
+	for _, backend := range server.backendStats() {
+		s := backend.stats
+		reply = append(reply, []interface{}{
+			[]byte("address"), []byte(backend.address),
+			[]byte("role"), []byte(backend.role),
+			[]byte("active"), int64(s.ActiveCount),
+			[]byte("idle"), int64(s.IdleCount),
+			[]byte("waits"), s.WaitCount,
+			[]byte("wait_ms"), s.WaitDuration.Nanoseconds() / int64(time.Millisecond),
+			[]byte("dial_errors"), s.DialErrors,
+			[]byte("test_failures"), s.TestFailures,
+		})
+	}
+	return reply
+}
+
+/*
+	one line per client like CLIENT LIST
+ */
+func (server *Server) clientList() []byte {
+	server.mu.Lock()
+	clients := make([]*Client, 0, len(server.clients))
+	states := make(map[*Client]bool, len(server.clients))
+	for client, active := range server.clients {
+		clients = append(clients, client)
+		states[client] = active
+	}
+	server.mu.Unlock()
+	sort.Slice(clients, func(i, j int) bool { return clients[i].id < clients[j].id })
+
+	var b bytes.Buffer
+	for _, client := range clients {
+		var addr string
+		if client.conn != nil {
+			addr = client.conn.RemoteAddr().String()
+		}
+		state := "idle"
+		if states[client] {
+			state = "active"
+		}
+		// user和name在流水线中随AUTH和CLIENT SETNAME改变，订阅由pubsub.mu保护
+		client.mu.Lock()
+		var user string
+		if client.user != nil {
+			user = client.user.name
+		}
+		name := client.name
+		client.mu.Unlock()
+		server.pubsub.mu.Lock()
+		sub := client.subscriptions()
+		server.pubsub.mu.Unlock()
+		fmt.Fprintf(&b, "id=%d addr=%s name=%s age=%d state=%s user=%s sub=%d\n",
+			client.id, addr, name, int64(time.Since(client.created).Seconds()), state, user, sub)
+	}
+	return b.Bytes()
+}
+
+/*
+	config keys matching a glob pattern and their values, flattened to dotted keys
+ */
+func (server *Server) configGet(pattern string) ([]interface{}, error) {
+	server.configMu.RLock()
+	tree, err := configTree(server.config)
+	server.configMu.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+	maskSecrets(tree)
+	values := make(map[string]string)
+	flattenConfig("", tree, values)
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		if globMatch(strings.ToLower(pattern), key) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	reply := make([]interface{}, 0, 2*len(keys))
+	for _, key := range keys {
+		reply = append(reply, []byte(key), []byte(values[key]))
+	}
+	return reply, nil
+}
+
+/*
+	set a config key and reload, the value of a string key is taken as is,
+	other values are json such as 64, true or ["KEYS"]
+ */
+func (server *Server) configSet(key, value string) error {
+	server.reloadMu.Lock()
+	defer server.reloadMu.Unlock()
+	server.configMu.RLock()
+	current := server.config
+	tree, err := configTree(current)
+	server.configMu.RUnlock()
+	if err != nil {
+		return err
+	}
+
+	fields := strings.Split(strings.ToLower(key), ".")
+	parent := tree
+	for _, field := range fields[:len(fields)-1] {
+		child, ok := parent[field].(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("unknown config key '%s'", key)
+		}
+		parent = child
+	}
+	last := fields[len(fields)-1]
+	old, ok := parent[last]
+	if _, isMap := old.(map[string]interface{}); !ok || isMap {
+		return fmt.Errorf("unknown config key '%s'", key)
+	}
+	if _, isString := old.(string); isString {
+		parent[last] = value
+	} else {
+		var v interface{}
+		if err := json.Unmarshal([]byte(value), &v); err != nil {
+			return fmt.Errorf("invalid value for '%s': %v", key, err)
+		}
+		parent[last] = v
+	}
+
+	data, err := json.Marshal(tree)
+	if err != nil {
+		return err
+	}
+	config, err := ParseConfig(data)
+	if err != nil {
+		return err
+	}
+	config.path = current.path
+	return server.reload(config)
+}
+
+/*
+	config as nested json objects
+ */
+func configTree(config *Config) (map[string]interface{}, error) {
+	data, err := json.Marshal(config)
+	if err != nil {
+		return nil, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var tree map[string]interface{}
+	if err := decoder.Decode(&tree); err != nil {
+		return nil, err
+	}
+	return tree, nil
+}
+
+/*
+	mask the values of non-empty password keys, inside arrays too
+ */
+func maskSecrets(v interface{}) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for key, value := range v {
+			if s, ok := value.(string); ok && strings.HasSuffix(key, "password") && s != "" {
+				v[key] = maskedSecret
+			} else {
+				maskSecrets(value)
+			}
+		}
+	case []interface{}:
+		for _, value := range v {
+			maskSecrets(value)
+		}
+	}
+}
+
+/*
+	objects become dotted keys, arrays stay json
+ */
+func flattenConfig(prefix string, tree map[string]interface{}, values map[string]string) {
+	for key, value := range tree {
+		if prefix != "" {
+			key = prefix + "." + key
+		}
+		switch v := value.(type) {
+		case map[string]interface{}:
+			flattenConfig(key, v, values)
+		case string:
+			values[key] = v
+		case nil:
+			values[key] = ""
+		case []interface{}:
+			data, _ := json.Marshal(v)
+			values[key] = string(data)
+		default:
+			values[key] = fmt.Sprint(v)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package module
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"redisProxy/proxy"
+)
+
+func newAdminServer(t *testing.T, config string) (*Server, func(string) string) {
+	path := filepath.Join(t.TempDir(), "redisProxy.json")
+	if err := ioutil.WriteFile(path, []byte(config), 0600); err != nil {
+		t.Fatal(err)
+	}
+	c, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("LoadConfig() returned error %v", err)
+	}
+	server, err := NewServer(c)
+	if err != nil {
+		t.Fatalf("NewServer() returned error %v", err)
+	}
+	run := func(input string) string {
+		var buf bytes.Buffer
+		client := &Client{
+			server: server,
+			reader: bufio.NewReader(strings.NewReader(input)),
+			writer: proxy.NewWriter(&buf, 4096),
+		}
+		commands, err := client.readPipeline()
+		if err != nil {
+			t.Fatalf("readPipeline() returned error %v", err)
+		}
+		if err := server.execute(client, commands); err != nil {
+			t.Fatalf("execute() returned error %v", err)
+		}
+		return buf.String()
+	}
+	return server, run
+}
+
+func TestServer_admin(t *testing.T) {
+	server, run := newAdminServer(t, `{
+		"backend": {"addresses": ["127.0.0.1:1"], "password": "backendpw"},
+		"users": [{"name": "default", "password": "secret", "commands": ["+@all"]}]
+	}`)
+
+	if reply := run("PROXY INFO\r\n"); !strings.HasPrefix(reply, "-NOAUTH ") {
+		t.Errorf("PROXY INFO before AUTH wrote %q", reply)
+	}
+	reply := run("AUTH secret\r\nPROXY INFO\r\n")
+	for _, s := range []string{"# Proxy\r\n", "backend_mode:single\r\n", "backend0:address=127.0.0.1:1,role=master,"} {
+		if !strings.Contains(reply, s) {
+			t.Errorf("PROXY INFO wrote %q, want it to contain %q", reply, s)
+		}
+	}
+	if reply := run("AUTH secret\r\nPROXY POOLS\r\n"); !strings.HasPrefix(reply, "+OK\r\n*1\r\n*16\r\n$7\r\naddress\r\n$11\r\n127.0.0.1:1\r\n") {
+		t.Errorf("PROXY POOLS wrote %q", reply)
+	}
+
+	// 密码不会返回给客户端
+	if reply := run("AUTH secret\r\nPROXY CONFIG GET backend.pass*\r\n"); reply != "+OK\r\n*2\r\n$16\r\nbackend.password\r\n$6\r\n******\r\n" {
+		t.Errorf("PROXY CONFIG GET backend.pass* wrote %q", reply)
+	}
+	if reply := run("AUTH secret\r\nPROXY CONFIG GET users\r\n"); strings.Contains(reply, "secret") {
+		t.Errorf("PROXY CONFIG GET users wrote %q", reply)
+	}
+
+	if reply := run("AUTH secret\r\nPROXY CONFIG SET pool.max_active 8\r\nPROXY CONFIG GET pool.max_active\r\n"); reply != "+OK\r\n+OK\r\n*2\r\n$15\r\npool.max_active\r\n$1\r\n8\r\n" {
+		t.Errorf("PROXY CONFIG SET pool.max_active wrote %q", reply)
+	}
+	if server.config.Pool.MaxActive != 8 || server.config.Backend.Password != "backendpw" {
+		t.Errorf("CONFIG SET applied %+v", server.config)
+	}
+	if reply := run("AUTH secret\r\nPROXY CONFIG SET pool.max_active eight\r\n"); !strings.HasPrefix(reply, "+OK\r\n-ERR proxy: invalid value for 'pool.max_active'") {
+		t.Errorf("PROXY CONFIG SET with a bad value wrote %q", reply)
+	}
+	if reply := run("AUTH secret\r\nPROXY CONFIG SET pool.size 8\r\n"); reply != "+OK\r\n-ERR proxy: unknown config key 'pool.size'\r\n" {
+		t.Errorf("PROXY CONFIG SET with an unknown key wrote %q", reply)
+	}
+	if reply := run("AUTH secret\r\nPROXY CONFIG GET\r\n"); reply != "+OK\r\n-ERR wrong number of arguments for 'proxy config get' command\r\n" {
+		t.Errorf("PROXY CONFIG GET without a pattern wrote %q", reply)
+	}
+}
+
+func TestServer_adminFilterReload(t *testing.T) {
+	server, run := newAdminServer(t, `{"backend": {"addresses": ["127.0.0.1:1"]}, "filter": {"deny": ["KEYS"]}, "admin": {"enabled": true}}`)
+	old := server.router
+
+	data := `{"backend": {"addresses": ["127.0.0.1:2"]}, "filter": {"deny": ["GET"]}, "admin": {"enabled": true}}`
+	if err := ioutil.WriteFile(server.config.path, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if reply := run("PROXY FILTER RELOAD\r\nGET a\r\n"); reply != "+OK\r\n-ERR command 'GET' is not allowed by proxy\r\n" {
+		t.Errorf("PROXY FILTER RELOAD wrote %q", reply)
+	}
+	// 只重新加载过滤规则
+	if server.router != old || server.config.Backend.Addresses[0] != "127.0.0.1:1" {
+		t.Errorf("PROXY FILTER RELOAD changed the backends")
+	}
+}
+
+func TestServer_adminClients(t *testing.T) {
+	server, run := newAdminServer(t, `{"backend": {"addresses": ["127.0.0.1:1"]}}`)
+	server.addClient(&Client{id: 2})
+	server.addClient(&Client{id: 1})
+	reply := run("PROXY CLIENTS\r\n")
+	lines := strings.Split(strings.TrimSuffix(reply[strings.Index(reply, "\r\n")+2:], "\n\r\n"), "\n")
+	if len(lines) != 2 || !strings.HasPrefix(lines[0], "id=1 ") || !strings.HasSuffix(lines[1], " state=idle user= sub=0") {
+		t.Errorf("PROXY CLIENTS wrote %q", reply)
+	}
+}
+
+func TestServer_adminDisabled(t *testing.T) {
+	server, run := newAdminServer(t, `{"backend": {"addresses": ["127.0.0.1:1"], "password": "backendpw"}}`)
+	for _, input := range []string{
+		"PROXY CONFIG SET backend.addresses 10.0.0.9:6379\r\n",
+		"PROXY FILTER RELOAD\r\n",
+		"PROXY RELOAD\r\n",
+		"PROXY SLOWLOG RESET\r\n",
+	} {
+		if reply := run(input); !strings.HasPrefix(reply, "-NOPERM 'proxy ") {
+			t.Errorf("%q without users and admin.enabled wrote %q", input, reply)
+		}
+	}
+	if server.config.Backend.Addresses[0] != "127.0.0.1:1" {
+		t.Errorf("CONFIG SET was applied")
+	}
+	// 只读的子命令不受限制
+	if reply := run("PROXY SLOWLOG LEN\r\n"); reply != ":0\r\n" {
+		t.Errorf("PROXY SLOWLOG LEN wrote %q", reply)
+	}
+}
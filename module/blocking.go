@@ -0,0 +1,225 @@
+package module
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+	"redisProxy/proxy"
+	"redisProxy/redis"
+)
+
+/*
+	blocking commands run on a dedicated backend connection dialed outside the pool,
+	so that they neither hold a pooled connection nor hit the backend read timeout
+	the timeout (see blockingCommands) is capped by Backend.MaxBlockingTimeout,
+	a client disconnect closes the backend connection and cancels the command
+	the dedicated connections of a pool are limited by Pool.MaxBlocking, beyond it the command fails
+ */
+
+const blockingMargin = time.Second	// 读超时在阻塞超时之外的余量
+
+const (
+	timeoutLast	= -1	// 最后一个参数，单位秒
+	timeoutBlock	= -2	// BLOCK选项的值，单位毫秒，没有BLOCK时不阻塞
+)
+
+/*
+	blocking commands and the position of their timeout argument
+ */
+var blockingCommands = map[string]int{
+	"BLPOP":      timeoutLast,
+	"BRPOP":      timeoutLast,
+	"BRPOPLPUSH": timeoutLast,
+	"BLMOVE":     timeoutLast,
+	"BZPOPMIN":   timeoutLast,
+	"BZPOPMAX":   timeoutLast,
+	"BLMPOP":     0,	// BLMPOP timeout numkeys key [key ...] LEFT|RIGHT
+	"BZMPOP":     0,
+	"XREAD":      timeoutBlock,
+	"XREADGROUP": timeoutBlock,
+}
+
+/*
+	index of the timeout argument of a blocking command and its unit,
+	ok is false for commands that do not block
+ */
+func timeoutArg(command *proxy.Command) (index int, unit time.Duration, ok bool) {
+	position, ok := blockingCommands[command.CommandName()]
+	switch {
+	case !ok:
+		return 0, 0, false
+	case position == timeoutLast:
+		return len(command.Args) - 1, time.Second, len(command.Args) > 0
+	case position == timeoutBlock:
+		// XREAD [COUNT count] [BLOCK ms] STREAMS key [key ...] id [id ...]
+		for i, arg := range command.Args {
+			option := strings.ToUpper(string(arg))
+			if option == "STREAMS" {
+				break
+			}
+			if option == "BLOCK" && i+1 < len(command.Args) {
+				return i + 1, time.Millisecond, true
+			}
+		}
+		return 0, 0, false
+	}
+	return position, time.Second, position < len(command.Args)
+}
+
+/*
+	blocking commands inside a transaction do not block, they go with the pipeline
+ */
+func (server *Server) blocks(client *Client, command *proxy.Command) bool {
+	_, _, ok := timeoutArg(command)
+	return ok && client.pinned == nil && client.state == 0
+}
+
+func (server *Server) block(client *Client, command *proxy.Command) error {
+	reply, err := server.blockingReply(client, command)
+	if err != nil {
+		return err
+	}
+	return client.write(reply)
+}
+
+/*
+	reply of a blocking command, err is set when the client is gone
+ */
+func (server *Server) blockingReply(client *Client, command *proxy.Command) (interface{}, error) {
+	c, pool, command, timeout, reply := server.blockingConn(client, command)
+	if c == nil {
+		return reply, nil
+	}
+	defer server.releaseBlocking(pool)
+	defer c.Close()
+	c.Send(string(command.Name), commandArgs(command)...)
+	if err := c.Flush(); err != nil {
+		return redis.Error("ERR proxy: " + err.Error()), nil
+	}
+	var readTimeout time.Duration
+	if timeout > 0 {
+		readTimeout = timeout + blockingMargin
+	}
+	stop := client.watch(c)
+	reply, err := redis.ReceiveWithTimeout(c, readTimeout)
+	if disconnected := stop(); disconnected {
+		return nil, errClientClosed
+	}
+	if e, ok := err.(redis.Error); ok {
+		return e, nil
+	} else if err != nil {
+		return redis.Error("ERR proxy: " + err.Error()), nil
+	}
+	return reply, nil
+}
+
+/*
+	dedicated connection of a blocking command, or the reply when the command is rejected
+	the config lock is not held while the command blocks
+ */
+func (server *Server) blockingConn(client *Client, command *proxy.Command) (redis.Conn, *redis.Pool, *proxy.Command, time.Duration, interface{}) {
+	server.configMu.RLock()
+	defer server.configMu.RUnlock()
+	if err := server.acl.check(client, command); err != nil {
+		return nil, nil, nil, 0, err
+	}
+	if err := server.filterCheck(command); err != nil {
+		return nil, nil, nil, 0, err
+	}
+	pool, err := server.router.route(command)
+	if err != nil {
+		return nil, nil, nil, 0, err
+	}
+	if !server.acquireBlocking(pool) {
+		return nil, nil, nil, 0, redis.Error(fmt.Sprintf("ERR proxy: max number of blocking connections reached (pool.max_blocking %d)", server.config.Pool.MaxBlocking))
+	}
+	command, timeout := server.capTimeout(command)
+	c, err := pool.Dial()
+	if err != nil {
+		server.releaseBlocking(pool)
+		return nil, nil, nil, 0, redis.Error("ERR proxy: " + err.Error())
+	}
+	return c, pool, command, timeout, nil
+}
+
+/*
+	count a blocking connection of a pool, false at Pool.MaxBlocking
+	the caller holds configMu
+ */
+func (server *Server) acquireBlocking(pool *redis.Pool) bool {
+	server.blockingMu.Lock()
+	defer server.blockingMu.Unlock()
+	max := server.config.Pool.MaxBlocking
+	if max > 0 && server.blocking[pool] >= max {
+		return false
+	}
+	if server.blocking == nil {
+		server.blocking = make(map[*redis.Pool]int)
+	}
+	server.blocking[pool]++
+	return true
+}
+
+func (server *Server) releaseBlocking(pool *redis.Pool) {
+	server.blockingMu.Lock()
+	defer server.blockingMu.Unlock()
+	if server.blocking[pool]--; server.blocking[pool] <= 0 {
+		delete(server.blocking, pool)
+	}
+}
+
+/*
+	cap the timeout of a blocking command, 0 means forever
+	commands with a bad timeout are sent as is for redis to reply the error
+ */
+func (server *Server) capTimeout(command *proxy.Command) (*proxy.Command, time.Duration) {
+	i, unit, ok := timeoutArg(command)
+	if !ok {
+		return command, 0
+	}
+	value, err := strconv.ParseFloat(string(command.Args[i]), 64)
+	if err != nil || value < 0 {
+		return command, 0
+	}
+	timeout := time.Duration(value * float64(unit))
+	max := server.config.Backend.MaxBlockingTimeout.value()
+	if max <= 0 || (timeout > 0 && timeout <= max) {
+		return command, timeout
+	}
+	capped := &proxy.Command{Name: command.Name, Args: make([][]byte, len(command.Args))}
+	copy(capped.Args, command.Args)
+	if unit == time.Millisecond {
+		// BLOCK只接受整数毫秒
+		capped.Args[i] = []byte(strconv.FormatInt(int64(max/time.Millisecond), 10))
+	} else {
+		capped.Args[i] = []byte(strconv.FormatFloat(max.Seconds(), 'f', -1, 64))
+	}
+	return capped, max
+}
+
+/*
+	watch the client connection while it waits on a blocking command,
+	a disconnect closes c to cancel the command
+	stop unblocks the watcher and reports whether the client disconnected
+ */
+func (client *Client) watch(c redis.Conn) (stop func() bool) {
+	done := make(chan bool, 1)
+	go func() {
+		_, err := client.reader.Peek(1)
+		if ne, ok := err.(net.Error); err == nil || (ok && ne.Timeout()) {
+			// 客户端发来了新命令或者被stop唤醒
+			done <- false
+			return
+		}
+		c.Close()
+		done <- true
+	}()
+	return func() bool {
+		client.conn.SetReadDeadline(time.Now())
+		disconnected := <-done
+		client.conn.SetReadDeadline(time.Time{})
+		return disconnected
+	}
+}
@@ -0,0 +1,187 @@
+package module
+
+import (
+	"bufio"
+	"io"
+	"io/ioutil"
+	"net"
+	"strings"
+	"testing"
+	"time"
+	"redisProxy/proxy"
+	"redisProxy/redis"
+)
+
+var capTimeoutTests = []struct {
+	max     Duration
+	command *proxy.Command
+	arg     string
+	timeout time.Duration
+}{
+	{"", newCommand("BLPOP", "l", "0"), "0", 0},
+	{"", newCommand("BLPOP", "l", "5"), "5", 5 * time.Second},
+	{"30s", newCommand("BLPOP", "l", "0"), "30", 30 * time.Second},
+	{"30s", newCommand("BRPOP", "a", "b", "60"), "30", 30 * time.Second},
+	{"30s", newCommand("BRPOPLPUSH", "a", "b", "0.5"), "0.5", 500 * time.Millisecond},
+	{"1500ms", newCommand("BZPOPMIN", "z", "0"), "1.5", 1500 * time.Millisecond},
+	{"30s", newCommand("BLPOP", "l", "forever"), "forever", 0},
+	{"30s", newCommand("BLMOVE", "a", "b", "LEFT", "RIGHT", "0"), "30", 30 * time.Second},
+	{"30s", newCommand("BLMPOP", "60", "2", "a", "b", "LEFT", "COUNT", "2"), "30", 30 * time.Second},
+	{"30s", newCommand("BZMPOP", "5", "1", "z", "MIN"), "5", 5 * time.Second},
+	{"30s", newCommand("XREAD", "COUNT", "10", "BLOCK", "0", "STREAMS", "s", "$"), "30000", 30 * time.Second},
+	{"1500ms", newCommand("XREADGROUP", "GROUP", "g", "c", "BLOCK", "2000", "STREAMS", "s", ">"), "1500", 1500 * time.Millisecond},
+	{"30s", newCommand("XREAD", "BLOCK", "100", "STREAMS", "s", "$"), "100", 100 * time.Millisecond},
+}
+
+func TestServer_capTimeout(t *testing.T) {
+	for _, tt := range capTimeoutTests {
+		server := &Server{config: &Config{Backend: BackendConfig{MaxBlockingTimeout: tt.max}}}
+		i, _, ok := timeoutArg(tt.command)
+		if !ok {
+			t.Errorf("timeoutArg(%s %q) found no timeout", tt.command.Name, tt.command.Args)
+			continue
+		}
+		original := string(tt.command.Args[i])
+		command, timeout := server.capTimeout(tt.command)
+		arg := string(command.Args[i])
+		if arg != tt.arg || timeout != tt.timeout {
+			t.Errorf("capTimeout(%s %q) with max %q = %s, %v, want %s, %v", tt.command.Name, tt.command.Args, tt.max, arg, timeout, tt.arg, tt.timeout)
+		}
+		if string(tt.command.Args[i]) != original {
+			t.Errorf("capTimeout(%s %q) modified the command", tt.command.Name, tt.command.Args)
+		}
+	}
+	// 没有BLOCK的XREAD不阻塞
+	server := &Server{}
+	if server.blocks(&Client{}, newCommand("XREAD", "COUNT", "1", "STREAMS", "block", "0")) {
+		t.Errorf("blocks(XREAD without BLOCK) = true")
+	}
+	if !server.blocks(&Client{}, newCommand("XREADGROUP", "GROUP", "g", "c", "BLOCK", "0", "STREAMS", "s", ">")) {
+		t.Errorf("blocks(XREADGROUP BLOCK 0) = false")
+	}
+}
+
+/*
+	server whose pool dials one end of a pipe, the other end is the backend
+ */
+func newBlockingServer(backend net.Conn) *Server {
+	f, _ := newFilter(defaultFilterConfig())
+	pool := &redis.Pool{Dial: func() (redis.Conn, error) { return redis.NewCon(backend, time.Second, time.Second), nil }}
+	server := &Server{config: &Config{}, filter: f, router: &singleRouter{pool: pool}}
+	server.pubsub = newPubsub(server)
+	return server
+}
+
+func newPipeClient(server *Server) (*Client, net.Conn) {
+	conn, peer := net.Pipe()
+	return &Client{
+		conn:   conn,
+		server: server,
+		reader: bufio.NewReader(conn),
+		writer: proxy.NewWriter(conn, 4096),
+	}, peer
+}
+
+func TestServer_block(t *testing.T) {
+	backend, backendPeer := net.Pipe()
+	server := newBlockingServer(backend)
+	client, peer := newPipeClient(server)
+	defer peer.Close()
+	go func() {
+		// 超过后端读超时才回复
+		r := bufio.NewReader(backendPeer)
+		r.ReadString('\n')
+		time.Sleep(1500 * time.Millisecond)
+		backendPeer.Write([]byte("*2\r\n$1\r\nl\r\n$1\r\nv\r\n"))
+	}()
+	errc := make(chan error, 1)
+	go func() { errc <- server.execute(client, []*proxy.Command{newCommand("BLPOP", "l", "2")}) }()
+
+	line, err := bufio.NewReader(peer).ReadString('\n')
+	if err != nil || line != "*2\r\n" {
+		t.Fatalf("client read %q, %v, want *2", line, err)
+	}
+	if err := <-errc; err != nil {
+		t.Errorf("execute() returned error %v", err)
+	}
+}
+
+func TestServer_blockDisconnect(t *testing.T) {
+	backend, backendPeer := net.Pipe()
+	server := newBlockingServer(backend)
+	client, peer := newPipeClient(server)
+	closed := make(chan struct{})
+	go func() {
+		// 不回复，直到代理关闭连接
+		io.Copy(ioutil.Discard, backendPeer)
+		close(closed)
+	}()
+	errc := make(chan error, 1)
+	go func() { errc <- server.execute(client, []*proxy.Command{newCommand("BLPOP", "l", "0")}) }()
+
+	time.Sleep(100 * time.Millisecond)
+	peer.Close()
+	select {
+	case err := <-errc:
+		if err != errClientClosed {
+			t.Errorf("execute() = %v, want %v", err, errClientClosed)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("execute() did not return after the client disconnected")
+	}
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Error("backend connection was not closed")
+	}
+}
+
+func TestServer_blockFilter(t *testing.T) {
+	server := newBlockingServer(nil)
+	server.filter, _ = newFilter(FilterConfig{Deny: []string{"BLPOP"}})
+	client, peer := newPipeClient(server)
+	defer peer.Close()
+	go server.execute(client, []*proxy.Command{newCommand("BLPOP", "l", "0")})
+
+	line, _ := bufio.NewReader(peer).ReadString('\n')
+	if !strings.HasPrefix(line, "-ERR command 'BLPOP' is not allowed") {
+		t.Errorf("client read %q, want the filter error", line)
+	}
+}
+
+func TestServer_blockMaxBlocking(t *testing.T) {
+	backend, backendPeer := net.Pipe()
+	server := newBlockingServer(backend)
+	server.config.Pool.MaxBlocking = 1
+	go io.Copy(ioutil.Discard, backendPeer)
+	client, peer := newPipeClient(server)
+	errc := make(chan error, 1)
+	go func() { errc <- server.execute(client, []*proxy.Command{newCommand("BLPOP", "l", "0")}) }()
+	for i := 0; i < 100; i++ {
+		server.blockingMu.Lock()
+		n := len(server.blocking)
+		server.blockingMu.Unlock()
+		if n == 1 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// 达到上限的阻塞命令直接返回错误
+	other, otherPeer := newPipeClient(server)
+	defer otherPeer.Close()
+	go server.execute(other, []*proxy.Command{newCommand("BRPOP", "l", "0")})
+	line, _ := bufio.NewReader(otherPeer).ReadString('\n')
+	if !strings.HasPrefix(line, "-ERR proxy: max number of blocking connections reached") {
+		t.Errorf("client read %q, want the max_blocking error", line)
+	}
+
+	// 阻塞命令结束后释放
+	peer.Close()
+	if err := <-errc; err != errClientClosed {
+		t.Errorf("execute() = %v, want %v", err, errClientClosed)
+	}
+	if len(server.blocking) != 0 {
+		t.Errorf("blocking connections = %v after the command ended", server.blocking)
+	}
+}
@@ -3,8 +3,11 @@ package module
 import (
 	"net"
 	"bufio"
-	"log"
-	"io"
+	"sync"
+	"time"
+	"redisProxy/logger"
+	"redisProxy/proxy"
+	"redisProxy/redis"
 )
 
 /*
@@ -12,11 +15,42 @@ import (
  */
 
 type Client struct {
+	id		int64
+	created		time.Time
 	conn		net.Conn
 	server		*Server
 	reader		*bufio.Reader
-	writer		*bufio.Writer
+	decoder		*proxy.Decoder	// decodes commands from reader, see readCommand
+	writer		*proxy.Writer
 	bufferSize	int
+	// log with the client id and remote address of the connection
+	log		*logger.Logger
+
+	// pinned backend connection of a stateful session, see internal.LookupCommandInfo
+	pinned		redis.Conn
+	pinnedPool	*redis.Pool	// pool the pinned connection came from
+	state		int
+
+	// shard of the pinned session in sharded modes, see Server.transaction
+	shard		int
+	shardBound	bool
+	// a command of the transaction was rejected by the proxy, EXEC must fail
+	aborted		bool
+
+	// user authenticated by AUTH, nil until then, see acl
+	user		*aclUser
+	// name set by CLIENT SETNAME, kept by the proxy as backend connections are shared
+	name		string
+
+	// time of the last write, reads stick to the master for Backend.ReadYourWrites
+	lastWrite	time.Time
+
+	// pub/sub subscriptions and queue of pushed messages, guarded by pubsub.mu
+	channels	map[string]*subscriber
+	patterns	map[string]*subscriber
+	messages	chan []interface{}
+	// serializes replies and pushed messages
+	mu		sync.Mutex
 }
 
 /*
@@ -28,9 +62,16 @@ func (client *Client) Conn() net.Conn{
 
 
 /*
-	close conn
+	close conn and release the pinned backend connection
  */
 func (client *Client) Close() error{
+	if client.server != nil && client.server.pubsub != nil {
+		client.server.pubsub.close(client)
+	}
+	if client.pinned != nil {
+		client.pinned.Close()
+		client.pinned = nil
+	}
 	err := client.conn.Close()
 	if err != nil{
 		return nil
@@ -39,116 +80,83 @@ func (client *Client) Close() error{
 }
 
 /*
-	send bytes to redis-cli
+	subscriptions of a kind, created on first use
  */
-func (client *Client) SendBytes(b []byte) ([]byte) {
-	_, err := client.conn.Write(b)
-	if err != nil{
-		log.Fatal(err)
+func (client *Client) subscribed(kind string) map[string]*subscriber {
+	if kind == subscribePattern {
+		if client.patterns == nil {
+			client.patterns = make(map[string]*subscriber)
+		}
+		return client.patterns
+	}
+	if client.channels == nil {
+		client.channels = make(map[string]*subscriber)
 	}
-	return nil
+	return client.channels
+}
+
+func (client *Client) subscriptions() int {
+	return len(client.channels) + len(client.patterns)
 }
 
 /*
-	read a line from client buffer
+	write replies and flush, safe to call while messages are pushed
  */
-func (client *Client) readLine() ([]byte, error) {
-	message, err := client.reader.ReadSlice('\n')
-	if err != nil {
-		client.conn.Close()
-	}
-	i := len(message) - 2
-	if i < 0 || message[i] != '\r' {
-		return nil, err
+func (client *Client) write(replies ...interface{}) error {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	for _, reply := range replies {
+		if err := client.writer.WriteReply(reply); err != nil {
+			return err
+		}
 	}
-	return message[:i], err
+	return client.writer.Flush()
 }
 
 /*
-	get message size
+	send bytes to redis-cli, a failed write is the error of this client only
  */
-func (client *Client) peek() (int, error) {
-	client.reader.Peek(1)
-	length := client.reader.Buffered()
-	return length, nil
+func (client *Client) SendBytes(b []byte) error {
+	_, err := client.conn.Write(b)
+	return err
 }
 
 /*
-	read all message
+	pool of a part, parts on the pinned connection have no pool of their own
  */
-func (client *Client) readAll() ([]byte, error){
-	msgLen, err := client.peek()
-	if err != nil {
-		log.Fatal(err)
-	}
-	b := make([]byte, msgLen)
-	n, err := client.reader.Read(b)
-	if err != nil {
-		log.Fatal(err)
+func (client *Client) partPool(p *part) *redis.Pool {
+	if p.pool == nil {
+		return client.pinnedPool
 	}
-	return b[:n], err
+	return p.pool
 }
 
-
 /*
-	read messages based on readLine()
+	read one command sent by redis-cli, multi bulk or inline
+	the decoder shares the client reader and caps argument counts and lengths like redis-server,
+	empty requests and blank lines are skipped
  */
-func (client *Client) readReply() (interface{}, error) {
-	var utils utils		//引入工具类
-	line, err := client.readLine()
-	if err != nil {
-		return nil, err
-	}
-	if len(line) == 0 {
-		return nil, protocolError("short response line")
-	}
-	switch line[0] {
-	case '-':
-		return string(line[1:]), nil
-	case ':':
-		return utils.parseInt(line[1:])
-	case '$':
-		n, err := utils.parseLen(line[1:])
-		if n < 0 || err != nil {
-			return nil, err
-		}
-		p := make([]byte, n)
-		_, err = io.ReadFull(client.reader, p)
-		if err != nil {
-			return nil, err
-		}
-		if line, err := client.readLine(); err != nil {
-			return nil, err
-		}else if len(line) != 0 {
-			return nil, protocolError("bad bulk string format")
-		}
-		return p, nil
-	case '*':
-		n, err := utils.parseLen(line[1:])
-		if n < 0 || err != nil {
-			return nil, err
-		}
-		r := make([]interface{}, n)
-		for i := range r {
-			r[i], err = client.readReply()
-			if err != nil {
-				return nil, err
-			}
-		}
-		return r, nil
+func (client *Client) readCommand() (*proxy.Command, error) {
+	if client.decoder == nil {
+		client.decoder = proxy.NewDecoder(client.reader, client.reader.Size())
 	}
-	return nil, protocolError("unexpected response line")
+	return client.decoder.Decode()
 }
 
 /*
-	返回处理完的数据
+	read pipelined commands
+	blocks for the first command, then decodes every command already buffered
  */
-func (client *Client) Receive() (reply interface{}, err error) {
-	if reply, err = client.readReply(); err != nil{
-		return nil, err
-	}
-	if err, ok := reply.(error); ok{
-		return nil, err
+func (client *Client) readPipeline() ([]*proxy.Command, error) {
+	var commands []*proxy.Command
+	for {
+		command, err := client.readCommand()
+		if err != nil {
+			return nil, err
+		}
+		commands = append(commands, command)
+		if client.reader.Buffered() == 0 {
+			return commands, nil
+		}
 	}
-	return
 }
@@ -0,0 +1,52 @@
+package module
+
+import (
+	"bufio"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+var readPipelineErrorTests = []string{
+	"*99999999999999\r\n",
+	"*99999999999999999999999999\r\n",
+	"*1\r\n$99999999999999\r\n",
+	"*1\r\n$536870913\r\n",
+	"*1\r\n*1\r\n*1\r\n$4\r\nPING\r\n",
+}
+
+func TestClient_readPipelineError(t *testing.T) {
+	for _, input := range readPipelineErrorTests {
+		client := &Client{reader: bufio.NewReader(strings.NewReader(input))}
+		if commands, err := client.readPipeline(); err == nil {
+			t.Errorf("readPipeline(%q) = %d commands, want an error", input, len(commands))
+		}
+	}
+}
+
+func TestClient_readPipelineBlankLines(t *testing.T) {
+	// 大量空行不会递归
+	input := strings.Repeat("\r\n", 1000000) + "PING\r\n"
+	client := &Client{reader: bufio.NewReader(strings.NewReader(input))}
+	commands, err := client.readPipeline()
+	if err != nil {
+		t.Fatalf("readPipeline() returned error %v", err)
+	}
+	if len(commands) != 1 || string(commands[0].Name) != "PING" {
+		t.Errorf("readPipeline() = %d commands, want PING", len(commands))
+	}
+}
+
+func TestClient_readPipelineBulkLen(t *testing.T) {
+	// 声明了很大的长度但没有发送数据，不会按声明的长度分配内存
+	client := &Client{reader: bufio.NewReader(strings.NewReader("*1\r\n$536870912\r\nPING"))}
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	if _, err := client.readPipeline(); err == nil {
+		t.Errorf("readPipeline() of a truncated argument returned no error")
+	}
+	runtime.ReadMemStats(&after)
+	if allocated := after.TotalAlloc - before.TotalAlloc; allocated > 1<<20 {
+		t.Errorf("readPipeline() allocated %d bytes", allocated)
+	}
+}
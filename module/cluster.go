@@ -0,0 +1,487 @@
+package module
+
+import (
+	"fmt"
+	"math/rand"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"redisProxy/logger"
+	"redisProxy/proxy"
+	"redisProxy/redis"
+)
+
+/*
+	redis cluster routing
+	key -> hash slot -> master node -> pool of the node
+ */
+
+const (
+	slotCount	= 16384
+	refreshInterval	= time.Second	// MOVED触发的刷新最小间隔
+)
+
+var crc16tab = [256]uint16{
+	0x0000, 0x1021, 0x2042, 0x3063, 0x4084, 0x50a5, 0x60c6, 0x70e7,
+	0x8108, 0x9129, 0xa14a, 0xb16b, 0xc18c, 0xd1ad, 0xe1ce, 0xf1ef,
+	0x1231, 0x0210, 0x3273, 0x2252, 0x52b5, 0x4294, 0x72f7, 0x62d6,
+	0x9339, 0x8318, 0xb37b, 0xa35a, 0xd3bd, 0xc39c, 0xf3ff, 0xe3de,
+	0x2462, 0x3443, 0x0420, 0x1401, 0x64e6, 0x74c7, 0x44a4, 0x5485,
+	0xa56a, 0xb54b, 0x8528, 0x9509, 0xe5ee, 0xf5cf, 0xc5ac, 0xd58d,
+	0x3653, 0x2672, 0x1611, 0x0630, 0x76d7, 0x66f6, 0x5695, 0x46b4,
+	0xb75b, 0xa77a, 0x9719, 0x8738, 0xf7df, 0xe7fe, 0xd79d, 0xc7bc,
+	0x48c4, 0x58e5, 0x6886, 0x78a7, 0x0840, 0x1861, 0x2802, 0x3823,
+	0xc9cc, 0xd9ed, 0xe98e, 0xf9af, 0x8948, 0x9969, 0xa90a, 0xb92b,
+	0x5af5, 0x4ad4, 0x7ab7, 0x6a96, 0x1a71, 0x0a50, 0x3a33, 0x2a12,
+	0xdbfd, 0xcbdc, 0xfbbf, 0xeb9e, 0x9b79, 0x8b58, 0xbb3b, 0xab1a,
+	0x6ca6, 0x7c87, 0x4ce4, 0x5cc5, 0x2c22, 0x3c03, 0x0c60, 0x1c41,
+	0xedae, 0xfd8f, 0xcdec, 0xddcd, 0xad2a, 0xbd0b, 0x8d68, 0x9d49,
+	0x7e97, 0x6eb6, 0x5ed5, 0x4ef4, 0x3e13, 0x2e32, 0x1e51, 0x0e70,
+	0xff9f, 0xefbe, 0xdfdd, 0xcffc, 0xbf1b, 0xaf3a, 0x9f59, 0x8f78,
+	0x9188, 0x81a9, 0xb1ca, 0xa1eb, 0xd10c, 0xc12d, 0xf14e, 0xe16f,
+	0x1080, 0x00a1, 0x30c2, 0x20e3, 0x5004, 0x4025, 0x7046, 0x6067,
+	0x83b9, 0x9398, 0xa3fb, 0xb3da, 0xc33d, 0xd31c, 0xe37f, 0xf35e,
+	0x02b1, 0x1290, 0x22f3, 0x32d2, 0x4235, 0x5214, 0x6277, 0x7256,
+	0xb5ea, 0xa5cb, 0x95a8, 0x8589, 0xf56e, 0xe54f, 0xd52c, 0xc50d,
+	0x34e2, 0x24c3, 0x14a0, 0x0481, 0x7466, 0x6447, 0x5424, 0x4405,
+	0xa7db, 0xb7fa, 0x8799, 0x97b8, 0xe75f, 0xf77e, 0xc71d, 0xd73c,
+	0x26d3, 0x36f2, 0x0691, 0x16b0, 0x6657, 0x7676, 0x4615, 0x5634,
+	0xd94c, 0xc96d, 0xf90e, 0xe92f, 0x99c8, 0x89e9, 0xb98a, 0xa9ab,
+	0x5844, 0x4865, 0x7806, 0x6827, 0x18c0, 0x08e1, 0x3882, 0x28a3,
+	0xcb7d, 0xdb5c, 0xeb3f, 0xfb1e, 0x8bf9, 0x9bd8, 0xabbb, 0xbb9a,
+	0x4a75, 0x5a54, 0x6a37, 0x7a16, 0x0af1, 0x1ad0, 0x2ab3, 0x3a92,
+	0xfd2e, 0xed0f, 0xdd6c, 0xcd4d, 0xbdaa, 0xad8b, 0x9de8, 0x8dc9,
+	0x7c26, 0x6c07, 0x5c64, 0x4c45, 0x3ca2, 0x2c83, 0x1ce0, 0x0cc1,
+	0xef1f, 0xff3e, 0xcf5d, 0xdf7c, 0xaf9b, 0xbfba, 0x8fd9, 0x9ff8,
+	0x6e17, 0x7e36, 0x4e55, 0x5e74, 0x2e93, 0x3eb2, 0x0ed1, 0x1ef0,
+}
+
+/*
+	CRC16/XMODEM, the checksum redis cluster uses for key slots
+ */
+func crc16(p []byte) uint16 {
+	var crc uint16
+	for _, b := range p {
+		crc = crc<<8 ^ crc16tab[byte(crc>>8)^b]
+	}
+	return crc
+}
+
+/*
+	hash slot of a key, only the hash tag is hashed when the key has one
+ */
+func hashSlot(key []byte) int {
+	return int(crc16(hashTag(key))) & (slotCount - 1)
+}
+
+/*
+	slots [start, end] served by a master and its replicas
+ */
+type slotRange struct {
+	start, end	int
+	master		string
+	replicas	[]string
+}
+
+type clusterRouter struct {
+	config	*Config
+	seeds	[]string
+	log	*logger.Logger
+
+	mu	sync.RWMutex
+	slots	[slotCount]string		// slot -> master address
+	pools	map[string]*redis.Pool	// address -> pool
+
+	refreshing	int32		// 后台刷新进行中
+	lastRefresh	int64		// 上次后台刷新的时间，UnixNano
+}
+
+/*
+	create cluster router, the slot table is loaded from the seed nodes
+ */
+func newClusterRouter(config *Config, log *logger.Logger) (*clusterRouter, error) {
+	r := &clusterRouter{
+		config: config,
+		log: log,
+		seeds: config.Backend.Addresses,
+		pools: make(map[string]*redis.Pool),
+	}
+	if err := r.refresh(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+/*
+	reload the slot table from the first node that answers, seeds first
+ */
+func (r *clusterRouter) refresh() error {
+	var err error
+	for _, address := range r.nodes() {
+		var slots []slotRange
+		if slots, err = r.loadSlots(address); err == nil {
+			r.update(slots)
+			return nil
+		}
+	}
+	return fmt.Errorf("cluster: cannot load slot table: %v", err)
+}
+
+/*
+	reload the slot table in the background, at most once per refreshInterval
+ */
+func (r *clusterRouter) refreshAsync() {
+	if time.Now().UnixNano()-atomic.LoadInt64(&r.lastRefresh) < int64(refreshInterval) {
+		return
+	}
+	if !atomic.CompareAndSwapInt32(&r.refreshing, 0, 1) {
+		return
+	}
+	go func() {
+		defer atomic.StoreInt32(&r.refreshing, 0)
+		atomic.StoreInt64(&r.lastRefresh, time.Now().UnixNano())
+		if err := r.refresh(); err != nil {
+			r.log.Warn("cluster slot table refresh failed", "err", err)
+		}
+	}()
+}
+
+/*
+	seed nodes followed by the known nodes
+ */
+func (r *clusterRouter) nodes() []string {
+	seen := make(map[string]bool)
+	var nodes []string
+	for _, address := range r.seeds {
+		if !seen[address] {
+			seen[address] = true
+			nodes = append(nodes, address)
+		}
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for address := range r.pools {
+		if !seen[address] {
+			seen[address] = true
+			nodes = append(nodes, address)
+		}
+	}
+	return nodes
+}
+
+/*
+	slot table of a node, CLUSTER SHARDS (redis 7) with CLUSTER SLOTS as fallback
+ */
+func (r *clusterRouter) loadSlots(address string) ([]slotRange, error) {
+	c, err := redis.Dial("tcp", address, r.config.Backend.dialOptions()...)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+	host, _, _ := net.SplitHostPort(address)
+	reply, err := c.Do("CLUSTER", "SHARDS")
+	if _, ok := err.(redis.Error); ok {
+		// 旧版本不支持CLUSTER SHARDS
+		if reply, err = c.Do("CLUSTER", "SLOTS"); err != nil {
+			return nil, err
+		}
+		return parseClusterSlots(reply, host)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return parseClusterShards(reply, host)
+}
+
+/*
+	replace the slot table, pools of new masters are created on first use
+ */
+func (r *clusterRouter) update(slots []slotRange) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i := range r.slots {
+		r.slots[i] = ""
+	}
+	for _, s := range slots {
+		for i := s.start; i <= s.end; i++ {
+			r.slots[i] = s.master
+		}
+	}
+}
+
+func (r *clusterRouter) route(command *proxy.Command) (*redis.Pool, error) {
+	keys := commandKeys(command)
+	if len(keys) == 0 {
+		if err := keylessError(command, backendModeCluster); err != nil {
+			return nil, err
+		}
+		return r.slotPool(rand.Intn(slotCount))
+	}
+	slot := hashSlot(keys[0])
+	for _, key := range keys[1:] {
+		if hashSlot(key) != slot {
+			return nil, redis.Error("CROSSSLOT Keys in request don't hash to the same slot")
+		}
+	}
+	return r.slotPool(slot)
+}
+
+/*
+	shard of a key is its hash slot
+ */
+func (r *clusterRouter) shard(key []byte) (int, *redis.Pool, error) {
+	slot := hashSlot(key)
+	pool, err := r.slotPool(slot)
+	return slot, pool, err
+}
+
+func (r *clusterRouter) slotPool(slot int) (*redis.Pool, error) {
+	r.mu.RLock()
+	address := r.slots[slot]
+	r.mu.RUnlock()
+	if address == "" {
+		return nil, redis.Error("CLUSTERDOWN Hash slot not served")
+	}
+	return r.pool(address), nil
+}
+
+func (r *clusterRouter) sharded() bool {
+	return true
+}
+
+/*
+	MOVED <slot> <address>: the slot moved for good, update it and reload the table
+	ASK <slot> <address>: the slot is migrating, only this command goes to the new node
+ */
+func (r *clusterRouter) redirect(err redis.Error) (*redis.Pool, bool) {
+	fields := strings.Fields(string(err))
+	if len(fields) != 3 || (fields[0] != "MOVED" && fields[0] != "ASK") {
+		return nil, false
+	}
+	slot, e := strconv.Atoi(fields[1])
+	if e != nil || slot < 0 || slot >= slotCount {
+		return nil, false
+	}
+	address := fields[2]
+	if _, _, e := net.SplitHostPort(address); e != nil {
+		return nil, false
+	}
+	if fields[0] == "ASK" {
+		return r.pool(address), true
+	}
+	r.mu.Lock()
+	r.slots[slot] = address
+	r.mu.Unlock()
+	r.refreshAsync()
+	return r.pool(address), false
+}
+
+/*
+	pool of a node, created on first use
+ */
+func (r *clusterRouter) pool(address string) *redis.Pool {
+	r.mu.RLock()
+	pool, ok := r.pools[address]
+	r.mu.RUnlock()
+	if ok {
+		return pool
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if pool, ok = r.pools[address]; !ok {
+		pool = newPool(address, r.config)
+		r.pools[address] = pool
+	}
+	return pool
+}
+
+func (r *clusterRouter) backends() map[string]*redis.Pool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	pools := make(map[string]*redis.Pool, len(r.pools))
+	for address, pool := range r.pools {
+		pools[address] = pool
+	}
+	return pools
+}
+
+func (r *clusterRouter) close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var err error
+	for address, pool := range r.pools {
+		if e := pool.Close(); e != nil && err == nil {
+			err = e
+		}
+		delete(r.pools, address)
+	}
+	return err
+}
+
+/*
+	CLUSTER SLOTS reply:
+	1) 1) start 2) end 3) 1) ip 2) port 3) id   (master)
+	                   4) 1) ip 2) port 3) id   (replicas...)
+	an empty ip means the node that answered, host is its address
+ */
+func parseClusterSlots(reply interface{}, host string) ([]slotRange, error) {
+	entries, err := redis.Values(reply, nil)
+	if err != nil {
+		return nil, err
+	}
+	var slots []slotRange
+	for _, entry := range entries {
+		fields, err := redis.Values(entry, nil)
+		if err != nil {
+			return nil, err
+		}
+		if len(fields) < 3 {
+			return nil, fmt.Errorf("cluster: bad CLUSTER SLOTS entry %v", fields)
+		}
+		s := slotRange{}
+		if s.start, err = redis.Int(fields[0], nil); err != nil {
+			return nil, err
+		}
+		if s.end, err = redis.Int(fields[1], nil); err != nil {
+			return nil, err
+		}
+		for i, node := range fields[2:] {
+			address, err := parseSlotsNode(node, host)
+			if err != nil {
+				return nil, err
+			}
+			if i == 0 {
+				s.master = address
+			} else {
+				s.replicas = append(s.replicas, address)
+			}
+		}
+		if err := s.check(); err != nil {
+			return nil, err
+		}
+		slots = append(slots, s)
+	}
+	return slots, nil
+}
+
+func parseSlotsNode(node interface{}, host string) (string, error) {
+	fields, err := redis.Values(node, nil)
+	if err != nil {
+		return "", err
+	}
+	if len(fields) < 2 {
+		return "", fmt.Errorf("cluster: bad CLUSTER SLOTS node %v", fields)
+	}
+	ip, err := redis.String(fields[0], nil)
+	if err != nil {
+		return "", err
+	}
+	port, err := redis.Int(fields[1], nil)
+	if err != nil {
+		return "", err
+	}
+	if ip == "" || ip == "?" {
+		ip = host
+	}
+	return net.JoinHostPort(ip, strconv.Itoa(port)), nil
+}
+
+/*
+	CLUSTER SHARDS reply, a list of flat maps:
+	1) "slots" 2) 1) start 2) end ...
+	3) "nodes" 4) 1) "id" ... "ip" ... "port" ... "role" "master" ... "health" "online"
+ */
+func parseClusterShards(reply interface{}, host string) ([]slotRange, error) {
+	shards, err := redis.Values(reply, nil)
+	if err != nil {
+		return nil, err
+	}
+	var slots []slotRange
+	for _, shard := range shards {
+		fields, err := flatMap(shard)
+		if err != nil {
+			return nil, err
+		}
+		ranges, err := redis.Values(fields["slots"], nil)
+		if err != nil {
+			return nil, err
+		}
+		nodes, err := redis.Values(fields["nodes"], nil)
+		if err != nil {
+			return nil, err
+		}
+		var master string
+		var replicas []string
+		for _, node := range nodes {
+			n, err := flatMap(node)
+			if err != nil {
+				return nil, err
+			}
+			ip, _ := redis.String(n["ip"], nil)
+			if ip == "" || ip == "?" {
+				ip = host
+			}
+			port, err := redis.Int(n["port"], nil)
+			if err != nil {
+				return nil, err
+			}
+			health, _ := redis.String(n["health"], nil)
+			role, _ := redis.String(n["role"], nil)
+			address := net.JoinHostPort(ip, strconv.Itoa(port))
+			if role == "master" {
+				master = address
+			} else if health == "online" {
+				replicas = append(replicas, address)
+			}
+		}
+		for i := 0; i+1 < len(ranges); i += 2 {
+			s := slotRange{master: master, replicas: replicas}
+			if s.start, err = redis.Int(ranges[i], nil); err != nil {
+				return nil, err
+			}
+			if s.end, err = redis.Int(ranges[i+1], nil); err != nil {
+				return nil, err
+			}
+			if err := s.check(); err != nil {
+				return nil, err
+			}
+			slots = append(slots, s)
+		}
+	}
+	return slots, nil
+}
+
+/*
+	RESP2 flat map [k1, v1, k2, v2 ...] to map
+ */
+func flatMap(reply interface{}) (map[string]interface{}, error) {
+	values, err := redis.Values(reply, nil)
+	if err != nil {
+		return nil, err
+	}
+	if len(values)%2 != 0 {
+		return nil, fmt.Errorf("cluster: odd number of map elements")
+	}
+	m := make(map[string]interface{}, len(values)/2)
+	for i := 0; i < len(values); i += 2 {
+		key, err := redis.String(values[i], nil)
+		if err != nil {
+			return nil, err
+		}
+		m[key] = values[i+1]
+	}
+	return m, nil
+}
+
+func (s *slotRange) check() error {
+	if s.start < 0 || s.end >= slotCount || s.start > s.end {
+		return fmt.Errorf("cluster: bad slot range %d-%d", s.start, s.end)
+	}
+	if s.master == "" {
+		return fmt.Errorf("cluster: slots %d-%d have no master", s.start, s.end)
+	}
+	return nil
+}
@@ -0,0 +1,168 @@
+package module
+
+import (
+	"reflect"
+	"testing"
+	"redisProxy/proxy"
+	"redisProxy/redis"
+)
+
+func TestHashSlot(t *testing.T) {
+	if crc := crc16([]byte("123456789")); crc != 0x31C3 {
+		t.Errorf("crc16(123456789) = %#x, want 0x31c3", crc)
+	}
+	for _, tt := range []struct {
+		key  string
+		slot int
+	}{
+		{"foo", 12182},
+		{"bar", 5061},
+		{"{user1000}.following", hashSlot([]byte("user1000"))},
+		{"foo{}{bar}", hashSlot([]byte("foo{}{bar}"))},
+		{"foo{{bar}}", hashSlot([]byte("{bar"))},
+		{"foo{bar}{zap}", hashSlot([]byte("bar"))},
+	} {
+		if slot := hashSlot([]byte(tt.key)); slot != tt.slot {
+			t.Errorf("hashSlot(%q) = %d, want %d", tt.key, slot, tt.slot)
+		}
+	}
+}
+
+var commandKeysTests = []struct {
+	command *proxy.Command
+	keys    []string
+}{
+	{newCommand("PING"), nil},
+	{newCommand("get", "a"), []string{"a"}},
+	{newCommand("MSET", "a", "1", "b", "2"), []string{"a", "b"}},
+	{newCommand("DEL", "a", "b", "c"), []string{"a", "b", "c"}},
+	{newCommand("BLPOP", "a", "b", "0"), []string{"a", "b"}},
+	{newCommand("OBJECT", "ENCODING", "a"), []string{"a"}},
+	{newCommand("EVAL", "return 1", "2", "a", "b", "x"), []string{"a", "b"}},
+	{newCommand("EVAL", "return 1", "0"), nil},
+	{newCommand("ZUNIONSTORE", "d", "2", "a", "b", "WEIGHTS", "1", "2"), []string{"d", "a", "b"}},
+	{newCommand("XREAD", "COUNT", "2", "STREAMS", "a", "b", "0", "0"), []string{"a", "b"}},
+}
+
+func TestCommandKeys(t *testing.T) {
+	for _, tt := range commandKeysTests {
+		var keys []string
+		for _, key := range commandKeys(tt.command) {
+			keys = append(keys, string(key))
+		}
+		if !reflect.DeepEqual(keys, tt.keys) {
+			t.Errorf("commandKeys(%s %q) = %q, want %q", tt.command.Name, tt.command.Args, keys, tt.keys)
+		}
+	}
+}
+
+func TestParseClusterSlots(t *testing.T) {
+	reply := []interface{}{
+		[]interface{}{int64(0), int64(8191),
+			[]interface{}{[]byte("10.0.0.1"), int64(7000), []byte("id1")},
+			[]interface{}{[]byte("10.0.0.2"), int64(7001), []byte("id2")},
+		},
+		[]interface{}{int64(8192), int64(16383),
+			[]interface{}{[]byte(""), int64(7002), []byte("id3")},
+		},
+	}
+	slots, err := parseClusterSlots(reply, "10.0.0.9")
+	if err != nil {
+		t.Fatalf("parseClusterSlots() returned error %v", err)
+	}
+	expected := []slotRange{
+		{start: 0, end: 8191, master: "10.0.0.1:7000", replicas: []string{"10.0.0.2:7001"}},
+		{start: 8192, end: 16383, master: "10.0.0.9:7002"},
+	}
+	if !reflect.DeepEqual(slots, expected) {
+		t.Errorf("parseClusterSlots() = %v, want %v", slots, expected)
+	}
+}
+
+func TestParseClusterShards(t *testing.T) {
+	node := func(ip string, port int64, role, health string) interface{} {
+		return []interface{}{
+			[]byte("id"), []byte("x"), []byte("port"), port, []byte("ip"), []byte(ip),
+			[]byte("role"), []byte(role), []byte("health"), []byte(health),
+		}
+	}
+	reply := []interface{}{
+		[]interface{}{
+			[]byte("slots"), []interface{}{int64(0), int64(100), int64(200), int64(300)},
+			[]byte("nodes"), []interface{}{
+				node("10.0.0.1", 7000, "master", "online"),
+				node("10.0.0.2", 7001, "replica", "online"),
+				node("10.0.0.3", 7002, "replica", "loading"),
+			},
+		},
+	}
+	slots, err := parseClusterShards(reply, "10.0.0.9")
+	if err != nil {
+		t.Fatalf("parseClusterShards() returned error %v", err)
+	}
+	replicas := []string{"10.0.0.2:7001"}
+	expected := []slotRange{
+		{start: 0, end: 100, master: "10.0.0.1:7000", replicas: replicas},
+		{start: 200, end: 300, master: "10.0.0.1:7000", replicas: replicas},
+	}
+	if !reflect.DeepEqual(slots, expected) {
+		t.Errorf("parseClusterShards() = %v, want %v", slots, expected)
+	}
+}
+
+func TestClusterRouter_route(t *testing.T) {
+	config := defaultConfig()
+	r := &clusterRouter{config: config, pools: make(map[string]*redis.Pool)}
+	r.update([]slotRange{
+		{start: 0, end: 8191, master: "10.0.0.1:7000"},
+		{start: 8192, end: 16382, master: "10.0.0.2:7000"},
+	})
+	defer r.close()
+
+	foo, err := r.route(newCommand("GET", "foo"))
+	if err != nil || foo != r.pool("10.0.0.2:7000") {
+		t.Errorf("route(GET foo) = %p, %v, want pool of 10.0.0.2:7000", foo, err)
+	}
+	bar, err := r.route(newCommand("GET", "bar"))
+	if err != nil || bar != r.pool("10.0.0.1:7000") {
+		t.Errorf("route(GET bar) = %p, %v, want pool of 10.0.0.1:7000", bar, err)
+	}
+	if _, err := r.route(newCommand("MGET", "foo", "bar")); err == nil || err.Error() != "CROSSSLOT Keys in request don't hash to the same slot" {
+		t.Errorf("route(MGET foo bar) returned error %v, want CROSSSLOT", err)
+	}
+	if _, err := r.route(newCommand("MGET", "{foo}a", "{foo}b")); err != nil {
+		t.Errorf("route(MGET {foo}a {foo}b) returned error %v", err)
+	}
+	// slot 16383 is not assigned
+	if _, err := r.route(newCommand("GET", "hia")); err == nil || err.Error() != "CLUSTERDOWN Hash slot not served" {
+		t.Errorf("route(GET hia) returned error %v, want CLUSTERDOWN", err)
+	}
+	// 没有key的命令只有明确不访问key时才发往任意master
+	if _, err := r.route(newCommand("PING")); err != nil {
+		t.Errorf("route(PING) returned error %v", err)
+	}
+	for _, name := range []string{"KEYS", "SCAN", "DBSIZE", "RANDOMKEY", "FLUSHDB", "FLUSHALL"} {
+		if _, err := r.route(newCommand(name, "0")); err == nil || err.Error() != "ERR proxy: command '"+name+"' has no key to route by in cluster mode" {
+			t.Errorf("route(%s) returned error %v", name, err)
+		}
+	}
+}
+
+func TestClusterRouter_redirect(t *testing.T) {
+	r := &clusterRouter{config: defaultConfig(), pools: make(map[string]*redis.Pool)}
+	// 不触发后台刷新
+	r.refreshing = 1
+	defer r.close()
+
+	if pool, _ := r.redirect(redis.Error("ERR unknown command")); pool != nil {
+		t.Errorf("redirect(ERR) returned a pool")
+	}
+	pool, asking := r.redirect(redis.Error("ASK 3999 10.0.0.3:7000"))
+	if pool != r.pool("10.0.0.3:7000") || !asking || r.slots[3999] != "" {
+		t.Errorf("redirect(ASK) = %p, %v, slot 3999 = %q", pool, asking, r.slots[3999])
+	}
+	pool, asking = r.redirect(redis.Error("MOVED 3999 10.0.0.4:7000"))
+	if pool != r.pool("10.0.0.4:7000") || asking || r.slots[3999] != "10.0.0.4:7000" {
+		t.Errorf("redirect(MOVED) = %p, %v, slot 3999 = %q", pool, asking, r.slots[3999])
+	}
+}
@@ -1,5 +1,487 @@
 package module
 
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"time"
+	"redisProxy/logger"
+	"redisProxy/redis"
+)
+
 /*
 	client & server config
+	loaded from a json file, e.g.
+
+	{
+		"listen": ":6380",
+		"backend": {
+			"addresses": ["127.0.0.1:6379"],
+			"password": "secret",
+			"connect_timeout": "1s"
+		},
+		"pool": {"max_idle": 16, "max_active": 256, "idle_timeout": "5m", "wait": true, "max_blocking": 64},
+		"filter": {"mode": "deny", "deny": ["KEYS", "CONFIG SET"]},
+		"users": [{"name": "app", "password": "secret", "commands": ["+@read", "+@write"], "keys": ["app:*"]}]
+	}
+ */
+type Config struct {
+	Listen	string		`json:"listen"`
+	Backend	BackendConfig	`json:"backend"`
+	Pool	PoolConfig	`json:"pool"`
+	Filter	FilterConfig	`json:"filter"`
+	Buffer	BufferConfig	`json:"buffer"`
+	Users	[]UserConfig	`json:"users"`
+	TLS	ListenTLSConfig	`json:"tls"`
+	Metrics	MetricsConfig	`json:"metrics"`
+	Slowlog	SlowlogConfig	`json:"slowlog"`
+	Log	LogConfig	`json:"log"`
+	Admin	AdminConfig	`json:"admin"`
+
+	path	string	// file the config was loaded from, see Server.ReloadFile
+}
+
+/*
+	prometheus /metrics endpoint, disabled when listen is empty
+ */
+type MetricsConfig struct {
+	Listen	string	`json:"listen"`
+}
+
+/*
+	slow command log, see PROXY SLOWLOG
+	commands slower than threshold in the proxy are kept, at most max_len of them,
+	an empty threshold disables the log, log also writes the entries to the process log
+ */
+type SlowlogConfig struct {
+	Threshold	Duration	`json:"threshold"`
+	MaxLen		int		`json:"max_len"`
+	Log		bool		`json:"log"`
+}
+
+/*
+	PROXY subcommands changing the proxy, CONFIG SET, FILTER RELOAD, RELOAD and SLOWLOG RESET,
+	are allowed to users granted the @admin category when users are configured,
+	and to every client only when enabled is set
+ */
+type AdminConfig struct {
+	Enabled	bool	`json:"enabled"`
+}
+
+/*
+	process log written to stderr, level is debug, info (default), warn or error,
+	format is "logfmt" (default) or "json"
+ */
+type LogConfig struct {
+	Level	string	`json:"level"`
+	Format	string	`json:"format"`
+}
+
+/*
+	logger of the config writing to w, the config is validated
+ */
+func (config LogConfig) Logger(w io.Writer) *logger.Logger {
+	level, _ := logger.ParseLevel(config.Level)
+	return logger.New(w, config.Format == logFormatJSON, level)
+}
+
+const (
+	logFormatLogfmt	= "logfmt"
+	logFormatJSON	= "json"
+)
+
+/*
+	TLS of the client listener, enabled when cert_file and key_file are set
+	client_ca_file requires clients to present a certificate signed by one of its CAs
+ */
+type ListenTLSConfig struct {
+	CertFile	string	`json:"cert_file"`
+	KeyFile		string	`json:"key_file"`
+	ClientCAFile	string	`json:"client_ca_file"`
+
+	config		*tls.Config	// loaded by validate
+}
+
+/*
+	TLS of backend and sentinel connections
+	ca_file verifies the servers instead of the system roots, server_name overrides the SNI host,
+	cert_file and key_file present a client certificate, skip_verify accepts any server certificate
+ */
+type BackendTLSConfig struct {
+	Enabled		bool	`json:"enabled"`
+	CAFile		string	`json:"ca_file"`
+	CertFile	string	`json:"cert_file"`
+	KeyFile		string	`json:"key_file"`
+	ServerName	string	`json:"server_name"`
+	SkipVerify	bool	`json:"skip_verify"`
+
+	config		*tls.Config	// loaded by validate
+}
+
+/*
+	user of proxy-side authentication, clients need not authenticate when there are no users
+	AUTH password authenticates as the user named "default"
+	commands are ACL rules applied in order such as "+@read" or "-FLUSHDB", the last matching rule wins,
+	categories: @all @read @write @pubsub @transaction @scripting @blocking @connection @admin
+	keys are glob patterns of the keys the user may access, empty means all keys
+ */
+type UserConfig struct {
+	Name		string		`json:"name"`
+	Password	string		`json:"password"`
+	Commands	[]string	`json:"commands"`
+	Keys		[]string	`json:"keys"`
+}
+
+/*
+	redis backend config
+	mode "single" (default) uses the first address,
+	mode "cluster" uses the addresses as seed nodes of a redis cluster,
+	MOVED/ASK redirects are followed at most max_redirects times
+	mode "ketama" shards keys over independent servers by consistent hashing,
+	servers default to the addresses with weight 1, hash_tag is two characters such as "{}"
+	mode "sentinel" uses the addresses as sentinels and follows the master named master_name
+	replicas serve read-only commands in single and sentinel mode, picked by weight,
+	a client reads from the master for read_your_writes after its last write
+	blocking commands (BLPOP...) wait at most max_blocking_timeout, empty means no cap
+ */
+type BackendConfig struct {
+	Mode		string		`json:"mode"`
+	Addresses	[]string	`json:"addresses"`
+	Servers		[]ServerConfig	`json:"servers"`
+	HashTag		string		`json:"hash_tag"`
+	MasterName	string		`json:"master_name"`
+	SentinelPassword	string	`json:"sentinel_password"`
+	Replicas	[]ServerConfig	`json:"replicas"`
+	ReadYourWrites	Duration	`json:"read_your_writes"`
+	Password	string		`json:"password"`
+	Database	int		`json:"database"`
+	ConnectTimeout	Duration	`json:"connect_timeout"`
+	ReadTimeout	Duration	`json:"read_timeout"`
+	WriteTimeout	Duration	`json:"write_timeout"`
+	MaxRedirects	int		`json:"max_redirects"`
+	MaxBlockingTimeout	Duration	`json:"max_blocking_timeout"`
+	TLS		BackendTLSConfig	`json:"tls"`
+}
+
+/*
+	weighted server of ketama mode or replica
+	name defaults to the address, keep the name when moving a server to keep its keys
+ */
+type ServerConfig struct {
+	Address	string	`json:"address"`
+	Weight	int	`json:"weight"`
+	Name	string	`json:"name"`
+}
+
+/*
+	backend connection pool config
+	max_blocking limits the dedicated connections of blocking commands per backend,
+	which are dialed outside the pool, 0 means no limit
+ */
+type PoolConfig struct {
+	MaxIdle		int		`json:"max_idle"`
+	MaxActive	int		`json:"max_active"`
+	IdleTimeout	Duration	`json:"idle_timeout"`
+	Wait		bool		`json:"wait"`
+	MaxBlocking	int		`json:"max_blocking"`
+}
+
+/*
+	client read & write buffer size in bytes
+ */
+type BufferConfig struct {
+	Read	int	`json:"read"`
+	Write	int	`json:"write"`
+}
+
+/*
+	command filter config
+	mode "deny" rejects the listed commands, mode "allow" accepts only the listed commands
+	rules are "COMMAND" or "COMMAND SUBCOMMAND", e.g. "CONFIG GET"
+	an empty filter config falls back to the default deny list
+ */
+type FilterConfig struct {
+	Mode	string		`json:"mode"`
+	Allow	[]string	`json:"allow"`
+	Deny	[]string	`json:"deny"`
+}
+
+/*
+	duration string such as "500ms" or "1m", empty means no timeout
+ */
+type Duration string
+
+func (d Duration) value() time.Duration {
+	v, _ := time.ParseDuration(string(d))
+	return v
+}
+
+/*
+	config error, Key points at the offending key, e.g. backend.addresses[1]
+ */
+type ConfigError struct {
+	Key	string
+	Err	error
+}
+
+func (e *ConfigError) Error() string {
+	return fmt.Sprintf("config: %s: %v", e.Key, e.Err)
+}
+
+/*
+	default config, keys missing from the config file keep these values
+ */
+func defaultConfig() *Config {
+	return &Config{
+		Listen: ":6380",
+		Backend: BackendConfig{
+			ConnectTimeout: "1s",
+			MaxRedirects: 5,
+		},
+		Pool: PoolConfig{
+			MaxIdle: 16,
+			IdleTimeout: "5m",
+			MaxBlocking: 64,
+		},
+		Buffer: BufferConfig{
+			Read: 4096,
+			Write: 4096,
+		},
+		Slowlog: SlowlogConfig{
+			Threshold: "10ms",
+			MaxLen: 128,
+		},
+	}
+}
+
+/*
+	load config from file
+ */
+func LoadConfig(path string) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	config, err := ParseConfig(data)
+	if err != nil {
+		return nil, err
+	}
+	config.path = path
+	return config, nil
+}
+
+/*
+	parse and validate json config
+ */
+func ParseConfig(data []byte) (*Config, error) {
+	config := defaultConfig()
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(config); err != nil {
+		switch e := err.(type) {
+		case *json.SyntaxError:
+			line, column := position(data, e.Offset)
+			return nil, fmt.Errorf("config: line %d column %d: %v", line, column, err)
+		case *json.UnmarshalTypeError:
+			return nil, &ConfigError{Key: e.Field, Err: fmt.Errorf("cannot use %s as %s", e.Value, e.Type)}
+		}
+		return nil, fmt.Errorf("config: %v", err)
+	}
+	if config.Filter.Mode == "" && config.Filter.Allow == nil && config.Filter.Deny == nil {
+		config.Filter = defaultFilterConfig()
+	}
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+/*
+	line and column of the byte a syntax error was found at
+	offset counts the bytes read, including the offending one
+ */
+func position(data []byte, offset int64) (int, int) {
+	line, column := 1, 1
+	if offset > 0 {
+		offset--
+	}
+	for _, b := range data[:offset] {
+		if b == '\n' {
+			line++
+			column = 1
+		} else {
+			column++
+		}
+	}
+	return line, column
+}
+
+func (config *Config) validate() error {
+	if _, _, err := net.SplitHostPort(config.Listen); err != nil {
+		return &ConfigError{Key: "listen", Err: err}
+	}
+	if config.Metrics.Listen != "" {
+		if _, _, err := net.SplitHostPort(config.Metrics.Listen); err != nil {
+			return &ConfigError{Key: "metrics.listen", Err: err}
+		}
+	}
+	switch config.Backend.Mode {
+	case "", backendModeSingle, backendModeCluster, backendModeKetama, backendModeSentinel:
+	default:
+		return &ConfigError{Key: "backend.mode", Err: fmt.Errorf("unknown backend mode %q", config.Backend.Mode)}
+	}
+	if len(config.Backend.Addresses) == 0 && (config.Backend.Mode != backendModeKetama || len(config.Backend.Servers) == 0) {
+		return &ConfigError{Key: "backend.addresses", Err: fmt.Errorf("at least one address is required")}
+	}
+	if config.Backend.Mode == backendModeSentinel && config.Backend.MasterName == "" {
+		return &ConfigError{Key: "backend.master_name", Err: fmt.Errorf("required in sentinel mode")}
+	}
+	for i, server := range config.Backend.Servers {
+		if _, _, err := net.SplitHostPort(server.Address); err != nil {
+			return &ConfigError{Key: fmt.Sprintf("backend.servers[%d].address", i), Err: err}
+		}
+		if server.Weight < 0 {
+			return &ConfigError{Key: fmt.Sprintf("backend.servers[%d].weight", i), Err: fmt.Errorf("must not be negative")}
+		}
+	}
+	if len(config.Backend.Replicas) != 0 && (config.Backend.Mode == backendModeCluster || config.Backend.Mode == backendModeKetama) {
+		return &ConfigError{Key: "backend.replicas", Err: fmt.Errorf("not supported in %s mode", config.Backend.Mode)}
+	}
+	for i, replica := range config.Backend.Replicas {
+		if _, _, err := net.SplitHostPort(replica.Address); err != nil {
+			return &ConfigError{Key: fmt.Sprintf("backend.replicas[%d].address", i), Err: err}
+		}
+		if replica.Weight < 0 {
+			return &ConfigError{Key: fmt.Sprintf("backend.replicas[%d].weight", i), Err: fmt.Errorf("must not be negative")}
+		}
+	}
+	if len(config.Backend.HashTag) != 0 && len(config.Backend.HashTag) != 2 {
+		return &ConfigError{Key: "backend.hash_tag", Err: fmt.Errorf("want two characters such as \"{}\"")}
+	}
+	for i, address := range config.Backend.Addresses {
+		if _, _, err := net.SplitHostPort(address); err != nil {
+			return &ConfigError{Key: fmt.Sprintf("backend.addresses[%d]", i), Err: err}
+		}
+	}
+	if config.Backend.Database < 0 {
+		return &ConfigError{Key: "backend.database", Err: fmt.Errorf("must not be negative")}
+	}
+	if config.Backend.MaxRedirects < 0 {
+		return &ConfigError{Key: "backend.max_redirects", Err: fmt.Errorf("must not be negative")}
+	}
+	durations := []struct {
+		key	string
+		d	Duration
+	}{
+		{"backend.connect_timeout", config.Backend.ConnectTimeout},
+		{"backend.read_timeout", config.Backend.ReadTimeout},
+		{"backend.write_timeout", config.Backend.WriteTimeout},
+		{"backend.read_your_writes", config.Backend.ReadYourWrites},
+		{"backend.max_blocking_timeout", config.Backend.MaxBlockingTimeout},
+		{"pool.idle_timeout", config.Pool.IdleTimeout},
+		{"slowlog.threshold", config.Slowlog.Threshold},
+	}
+	for _, duration := range durations {
+		if duration.d == "" {
+			continue
+		}
+		if v, err := time.ParseDuration(string(duration.d)); err != nil {
+			return &ConfigError{Key: duration.key, Err: err}
+		} else if v < 0 {
+			return &ConfigError{Key: duration.key, Err: fmt.Errorf("must not be negative")}
+		}
+	}
+	if config.Pool.MaxIdle < 0 {
+		return &ConfigError{Key: "pool.max_idle", Err: fmt.Errorf("must not be negative")}
+	}
+	if config.Pool.MaxActive < 0 {
+		return &ConfigError{Key: "pool.max_active", Err: fmt.Errorf("must not be negative")}
+	}
+	if config.Pool.MaxBlocking < 0 {
+		return &ConfigError{Key: "pool.max_blocking", Err: fmt.Errorf("must not be negative")}
+	}
+	if config.Slowlog.MaxLen < 1 {
+		return &ConfigError{Key: "slowlog.max_len", Err: fmt.Errorf("must be at least 1")}
+	}
+	if _, err := logger.ParseLevel(config.Log.Level); err != nil {
+		return &ConfigError{Key: "log.level", Err: err}
+	}
+	switch config.Log.Format {
+	case "", logFormatLogfmt, logFormatJSON:
+	default:
+		return &ConfigError{Key: "log.format", Err: fmt.Errorf("unknown log format %q, want logfmt or json", config.Log.Format)}
+	}
+	if config.Buffer.Read < 16 {
+		return &ConfigError{Key: "buffer.read", Err: fmt.Errorf("must be at least 16 bytes")}
+	}
+	if config.Buffer.Write < 16 {
+		return &ConfigError{Key: "buffer.write", Err: fmt.Errorf("must be at least 16 bytes")}
+	}
+	if _, err := newFilter(config.Filter); err != nil {
+		if e, ok := err.(*ConfigError); ok {
+			e.Key = "filter." + e.Key
+		}
+		return err
+	}
+	if err := config.TLS.load(); err != nil {
+		if e, ok := err.(*ConfigError); ok {
+			e.Key = "tls." + e.Key
+		}
+		return err
+	}
+	if err := config.Backend.TLS.load(); err != nil {
+		if e, ok := err.(*ConfigError); ok {
+			e.Key = "backend.tls." + e.Key
+		}
+		return err
+	}
+	if _, err := newACL(config.Users); err != nil {
+		if e, ok := err.(*ConfigError); ok {
+			e.Key = "users" + e.Key
+		}
+		return err
+	}
+	return nil
+}
+
+/*
+	dial options of sentinel connections, sentinels have no database and no read timeout
+ */
+func (config *BackendConfig) sentinelDialOptions() []redis.DialOption {
+	return append([]redis.DialOption{
+		redis.DialPassword(config.SentinelPassword),
+		redis.DialConnectTimeout(config.ConnectTimeout.value()),
+		redis.DialWriteTimeout(config.WriteTimeout.value()),
+	}, config.TLS.dialOptions()...)
+}
+
+/*
+	servers of ketama mode, the addresses with weight 1 when no server is configured
+ */
+func (config *BackendConfig) servers() []ServerConfig {
+	if len(config.Servers) != 0 {
+		return config.Servers
+	}
+	servers := make([]ServerConfig, len(config.Addresses))
+	for i, address := range config.Addresses {
+		servers[i] = ServerConfig{Address: address, Weight: 1}
+	}
+	return servers
+}
+
+/*
+	dial options of backend connections
  */
+func (config *BackendConfig) dialOptions() []redis.DialOption {
+	return append([]redis.DialOption{
+		redis.DialPassword(config.Password),
+		redis.DialDatabase(config.Database),
+		redis.DialConnectTimeout(config.ConnectTimeout.value()),
+		redis.DialReadTimeout(config.ReadTimeout.value()),
+		redis.DialWriteTimeout(config.WriteTimeout.value()),
+	}, config.TLS.dialOptions()...)
+}
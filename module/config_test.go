@@ -0,0 +1,77 @@
+package module
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseConfig(t *testing.T) {
+	config, err := ParseConfig([]byte(`{
+		"listen": "127.0.0.1:7000",
+		"backend": {"addresses": ["10.0.0.1:6379"], "password": "secret", "read_timeout": "500ms"},
+		"pool": {"max_active": 64},
+		"filter": {"mode": "allow", "allow": ["GET", "SET", "CONFIG GET"]}
+	}`))
+	if err != nil {
+		t.Fatalf("ParseConfig() returned error %v", err)
+	}
+	if config.Listen != "127.0.0.1:7000" || config.Backend.Addresses[0] != "10.0.0.1:6379" || config.Backend.Password != "secret" {
+		t.Errorf("ParseConfig() = %+v", config)
+	}
+	if config.Backend.ReadTimeout.value() != 500*time.Millisecond {
+		t.Errorf("backend.read_timeout = %v, want 500ms", config.Backend.ReadTimeout.value())
+	}
+	// keys missing from the file keep their defaults
+	if config.Pool.MaxActive != 64 || config.Pool.MaxIdle != 16 || config.Buffer.Read != 4096 {
+		t.Errorf("pool = %+v, buffer = %+v", config.Pool, config.Buffer)
+	}
+	if config.Filter.Mode != "allow" || len(config.Filter.Allow) != 3 || len(config.Filter.Deny) != 0 {
+		t.Errorf("filter = %+v", config.Filter)
+	}
+
+	config, err = ParseConfig([]byte(`{"backend": {"addresses": ["10.0.0.1:6379"]}}`))
+	if err != nil {
+		t.Fatalf("ParseConfig() returned error %v", err)
+	}
+	if config.Filter.Mode != "deny" || len(config.Filter.Deny) != len(defaultFilterConfig().Deny) {
+		t.Errorf("filter = %+v, want the default deny list", config.Filter)
+	}
+}
+
+var configErrorTests = []struct {
+	config string
+	err    string
+}{
+	{`{"listen": "6380"}`, "config: listen: "},
+	{`{"listen": ":6380"}`, "config: backend.addresses: "},
+	{`{"backend": {"addresses": ["a:1", "b"]}}`, "config: backend.addresses[1]: "},
+	{`{"backend": {"addresses": ["a:1"], "connect_timeout": "1x"}}`, "config: backend.connect_timeout: "},
+	{`{"backend": {"addresses": ["a:1"]}, "pool": {"max_idle": "8"}}`, "config: pool.max_idle: "},
+	{`{"backend": {"addresses": ["a:1"]}, "pool": {"max_active": -1}}`, "config: pool.max_active: "},
+	{`{"backend": {"addresses": ["a:1"]}, "filter": {"deny": ["KEYS", "CLIENT KILL NOW"]}}`, "config: filter.deny[1]: "},
+	{`{"backend": {"addresses": ["a:1"]}, "buffer": {"read": 1}}`, "config: buffer.read: "},
+	{`{"backend": {"mode": "twemproxy", "addresses": ["a:1"]}}`, "config: backend.mode: "},
+	{`{"backend": {"mode": "ketama", "servers": [{"address": "a:1"}, {"address": "b"}]}}`, "config: backend.servers[1].address: "},
+	{`{"backend": {"mode": "ketama", "servers": [{"address": "a:1", "weight": -1}]}}`, "config: backend.servers[0].weight: "},
+	{`{"backend": {"mode": "ketama", "addresses": ["a:1"], "hash_tag": "{"}}`, "config: backend.hash_tag: "},
+	{`{"backend": {"addresses": ["a:1"]}, "users": [{"name": "app", "password": "pw", "commands": ["+@nope"]}]}`, "config: users[0].commands[0]: "},
+	{`{"backend": {"addresses": ["a:1"]}, "tls": {"client_ca_file": "ca.pem"}}`, "config: tls.client_ca_file: "},
+	{`{"backend": {"addresses": ["a:1"]}, "tls": {"cert_file": "server.pem"}}`, "config: tls.key_file: "},
+	{`{"backend": {"addresses": ["a:1"], "tls": {"enabled": true, "ca_file": "/nonexistent/ca.pem"}}}`, "config: backend.tls.ca_file: "},
+	{`{"backend": {"addresses": ["a:1"]}, "slowlog": {"threshold": "-1ms"}}`, "config: slowlog.threshold: "},
+	{`{"backend": {"addresses": ["a:1"]}, "slowlog": {"max_len": 0}}`, "config: slowlog.max_len: "},
+	{`{"backend": {"addresses": ["a:1"]}, "log": {"level": "verbose"}}`, "config: log.level: "},
+	{`{"backend": {"addresses": ["a:1"]}, "log": {"format": "text"}}`, "config: log.format: "},
+	{`{"backend": {"adresses": ["a:1"]}}`, "unknown field"},
+	{"{\n\"listen\": \":6380\",,\n}", "config: line 2 column 19: "},
+}
+
+func TestParseConfigError(t *testing.T) {
+	for _, tt := range configErrorTests {
+		_, err := ParseConfig([]byte(tt.config))
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("ParseConfig(%s) = %v, want error containing %q", tt.config, err, tt.err)
+		}
+	}
+}
@@ -1,9 +1,32 @@
 package module
 
-import "fmt"
+import (
+	"errors"
+	"fmt"
+)
 
 type protocolError string
 
 func (pe protocolError) Error() string{
 	return fmt.Sprintf("proxy: %s", string(pe))
 }
+
+// the client disconnected while the proxy waited for a reply
+var errClientClosed = errors.New("proxy: client closed")
+
+/*
+	a backend connection failed in a pipeline, the client connection is closed
+	as the replies of its pipeline are lost
+ */
+type backendError struct {
+	address	string
+	err	error
+}
+
+func (e *backendError) Error() string {
+	return fmt.Sprintf("proxy: backend %s: %v", e.address, e.err)
+}
+
+func (e *backendError) Unwrap() error {
+	return e.err
+}
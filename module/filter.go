@@ -1,74 +1,123 @@
 package module
 
+import (
+	"fmt"
+	"strings"
+	"redisProxy/proxy"
+	"redisProxy/redis"
+)
+
 /*
 	1.redis command filter
 	2.error filter
  */
 
-type filter struct {}
+const (
+	filterModeDeny	= "deny"	// 拒绝规则中的命令，其余放行
+	filterModeAllow	= "allow"	// 只放行规则中的命令
+)
+
+/*
+	command filter
+	rules: "KEYS" or "CONFIG SET", true means allowed
+ */
+type filter struct {
+	mode	string
+	rules	map[string]bool
+}
+
+/*
+	build filter from config
+	subcommand rules override command rules, e.g. deny CLIENT but allow CLIENT SETNAME
+ */
+func newFilter(config FilterConfig) (*filter, error) {
+	f := &filter{
+		mode: config.Mode,
+		rules: make(map[string]bool),
+	}
+	if f.mode == "" {
+		f.mode = filterModeDeny
+	}
+	if f.mode != filterModeDeny && f.mode != filterModeAllow {
+		return nil, &ConfigError{Key: "mode", Err: fmt.Errorf("unknown filter mode %q", config.Mode)}
+	}
+	for i, rule := range config.Deny {
+		name, err := parseFilterRule(rule)
+		if err != nil {
+			return nil, &ConfigError{Key: fmt.Sprintf("deny[%d]", i), Err: err}
+		}
+		f.rules[name] = false
+	}
+	for i, rule := range config.Allow {
+		name, err := parseFilterRule(rule)
+		if err != nil {
+			return nil, &ConfigError{Key: fmt.Sprintf("allow[%d]", i), Err: err}
+		}
+		if allowed, ok := f.rules[name]; ok && !allowed {
+			return nil, &ConfigError{Key: fmt.Sprintf("allow[%d]", i), Err: fmt.Errorf("%q is also denied", rule)}
+		}
+		f.rules[name] = true
+	}
+	return f, nil
+}
+
+/*
+	normalize "config  set" to "CONFIG SET"
+ */
+func parseFilterRule(rule string) (string, error) {
+	fields := strings.Fields(strings.ToUpper(rule))
+	if len(fields) == 0 || len(fields) > 2 {
+		return "", fmt.Errorf("bad filter rule %q, want \"COMMAND\" or \"COMMAND SUBCOMMAND\"", rule)
+	}
+	return strings.Join(fields, " "), nil
+}
+
+/*
+	check command, returns a redis error reply naming the rejected command
+ */
+func (f *filter) check(command *proxy.Command) error {
+	name := command.CommandName()
+	if len(command.Args) > 0 {
+		sub := name + " " + strings.ToUpper(string(command.Args[0]))
+		if allowed, ok := f.rules[sub]; ok {
+			return f.result(sub, allowed)
+		}
+	}
+	if allowed, ok := f.rules[name]; ok {
+		return f.result(name, allowed)
+	}
+	return f.result(name, f.mode == filterModeDeny)
+}
+
+func (f *filter) result(name string, allowed bool) error {
+	if allowed {
+		return nil
+	}
+	return redis.Error(fmt.Sprintf("ERR command '%s' is not allowed by proxy", name))
+}
 
 /*
- 	command filter
+ 	default deny list of commands redisProxy does not support
   */
-func (f *filter) filter() map[string]bool{
-	filter := make(map[string]bool)
-	// keys
-	filter["KEYS"] = false
-	filter["MIGIRATE"] = false
-	filter["MOVE"] = false
-	filter["OBJECT"] = false
-	filter["DUMP"] = false
-	// lists部分
-	filter["BLPOP"] = false
-	filter["BRPOP"] = false
-	filter["BRPOPLPUSH"] = false
-	filter["RPOPLPUSH"] = false
-	// pub+sub
-	filter["PSUBSCRIBE"] = false
-	filter["PUBLISH"] = false
-	filter["PUBSUBSCRIBE"] = false
-	filter["SUBSCRIBE"] = false
-	filter["UNSUBSCRIBE"] = false
-	// transactions
-	filter["DISCARD"] = false
-	filter["EXEC"] = false
-	filter["MULTI"] = false
-	filter["UNWATCH"] = false
-	filter["WATCH"] = false
-	// scripting
-	filter["SCRIPT"] = false
-	filter["EVAL"] = false
-	filter["EVALSHA"] = false
-	// server
-	filter["BGREWRITEAOF"] = false
-	filter["BGSAVE"] = false
-	filter["CLIENT"] = false
-	filter["CONFIG"] = false
-	filter["DBSIZE"] = false
-	filter["DEBUG"] = false
-	filter["FLUSHALL"] = false
-	filter["FLUSHDB"] = false
-	filter["LASTSAVE"] = false
-	filter["LATENCY"] = false
-	filter["MONITOR"] = false
-	filter["PSYNC"] = false
-	filter["REPLCONF"] = false
-	filter["RESTORE"] = false
-	filter["SAVE"] = false
-	filter["SHUTDOWN"] = false
-	filter["SLAVEOF"] = false
-	filter["SYNC"] = false
-	filter["TIME"] = false
-	// slot
-	filter["SLOTSCHECK"] = false
-	filter["SLOTSDEL"] = false
-	filter["SLOTSINFO"] = false
-	filter["SLOTSMGRTONE"] = false
-	filter["SLOTSMGRTSLOT"] = false
-	filter["SLOTSMGRTTAGONE"] = false
-	filter["SLOTSMGRTTAGSLOT"] = false
-	// cluster
-	filter["READONLY"] = false
-	filter["READWRITE"] = false
-	return filter
+func defaultFilterConfig() FilterConfig {
+	return FilterConfig{
+		Mode: filterModeDeny,
+		Deny: []string{
+			// keys
+			"KEYS", "MIGRATE", "MOVE", "OBJECT", "DUMP",
+			// lists部分
+			"RPOPLPUSH",
+			// scripting
+			"SCRIPT KILL", "SCRIPT DEBUG",
+			// server
+			"BGREWRITEAOF", "BGSAVE", "CLIENT", "CONFIG", "DBSIZE", "DEBUG",
+			"FLUSHALL", "FLUSHDB", "LASTSAVE", "LATENCY", "MONITOR", "PSYNC",
+			"REPLCONF", "RESTORE", "SAVE", "SHUTDOWN", "SLAVEOF", "SYNC", "TIME",
+			// slot
+			"SLOTSCHECK", "SLOTSDEL", "SLOTSINFO", "SLOTSMGRTONE", "SLOTSMGRTSLOT",
+			"SLOTSMGRTTAGONE", "SLOTSMGRTTAGSLOT",
+			// cluster
+			"READONLY", "READWRITE",
+		},
+	}
 }
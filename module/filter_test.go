@@ -0,0 +1,81 @@
+package module
+
+import (
+	"testing"
+	"redisProxy/proxy"
+)
+
+func newCommand(args ...string) *proxy.Command {
+	command := &proxy.Command{Name: []byte(args[0])}
+	for _, arg := range args[1:] {
+		command.Args = append(command.Args, []byte(arg))
+	}
+	return command
+}
+
+var filterTests = []struct {
+	config  FilterConfig
+	command *proxy.Command
+	err     string
+}{
+	{defaultFilterConfig(), newCommand("get", "a"), ""},
+	{defaultFilterConfig(), newCommand("keys", "*"), "ERR command 'KEYS' is not allowed by proxy"},
+	{
+		FilterConfig{Deny: []string{"CLIENT"}, Allow: []string{"client setname"}},
+		newCommand("CLIENT", "setname", "app"),
+		"",
+	},
+	{
+		FilterConfig{Deny: []string{"CLIENT"}, Allow: []string{"client setname"}},
+		newCommand("CLIENT", "kill", "addr"),
+		"ERR command 'CLIENT' is not allowed by proxy",
+	},
+	{
+		FilterConfig{Mode: "allow", Allow: []string{"GET", "CONFIG GET"}},
+		newCommand("config", "get", "maxmemory"),
+		"",
+	},
+	{
+		FilterConfig{Mode: "allow", Allow: []string{"GET", "CONFIG GET"}},
+		newCommand("config", "set", "maxmemory", "0"),
+		"ERR command 'CONFIG' is not allowed by proxy",
+	},
+	{
+		FilterConfig{Mode: "allow", Allow: []string{"CONFIG"}, Deny: []string{"CONFIG SET"}},
+		newCommand("config", "set", "maxmemory", "0"),
+		"ERR command 'CONFIG SET' is not allowed by proxy",
+	},
+	{
+		FilterConfig{Mode: "allow", Allow: []string{"GET"}},
+		newCommand("set", "a", "1"),
+		"ERR command 'SET' is not allowed by proxy",
+	},
+}
+
+func TestFilter(t *testing.T) {
+	for _, tt := range filterTests {
+		f, err := newFilter(tt.config)
+		if err != nil {
+			t.Fatalf("newFilter(%v) returned error %v", tt.config, err)
+		}
+		err = f.check(tt.command)
+		if tt.err == "" && err != nil {
+			t.Errorf("check(%s %q) returned error %v", tt.command.Name, tt.command.Args, err)
+		} else if tt.err != "" && (err == nil || err.Error() != tt.err) {
+			t.Errorf("check(%s %q) = %v, want %s", tt.command.Name, tt.command.Args, err, tt.err)
+		}
+	}
+}
+
+func TestFilterConfigError(t *testing.T) {
+	for _, config := range []FilterConfig{
+		{Mode: "block"},
+		{Deny: []string{""}},
+		{Deny: []string{"CLIENT KILL NOW"}},
+		{Deny: []string{"KEYS"}, Allow: []string{"keys"}},
+	} {
+		if _, err := newFilter(config); err == nil {
+			t.Errorf("newFilter(%v) did not return expected error", config)
+		}
+	}
+}
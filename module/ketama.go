@@ -0,0 +1,171 @@
+package module
+
+import (
+	"bytes"
+	"crypto/md5"
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"redisProxy/proxy"
+	"redisProxy/redis"
+)
+
+/*
+	ketama consistent hashing over independent redis servers, compatible with twemproxy:
+	160 points per server scaled by weight, 4 points per md5 digest of "name-index"
+ */
+
+const (
+	ketamaPointsPerServer	= 160
+	ketamaPointsPerHash	= 4
+)
+
+type ketamaPoint struct {
+	value	uint32
+	server	int
+}
+
+type ketamaRouter struct {
+	continuum	[]ketamaPoint	// 按value排序
+	pools		[]*redis.Pool
+	addresses	[]string
+	tag		string
+}
+
+/*
+	create ketama router, each server has its own pool
+ */
+func newKetamaRouter(config *Config) *ketamaRouter {
+	servers := config.Backend.servers()
+	r := &ketamaRouter{
+		pools: make([]*redis.Pool, len(servers)),
+		addresses: make([]string, len(servers)),
+		tag: config.Backend.HashTag,
+	}
+	for i, server := range servers {
+		r.pools[i] = newPool(server.Address, config)
+		r.addresses[i] = server.Address
+	}
+	r.continuum = ketamaContinuum(servers)
+	return r
+}
+
+func ketamaContinuum(servers []ServerConfig) []ketamaPoint {
+	total := 0
+	for _, server := range servers {
+		total += ketamaWeight(server)
+	}
+	var continuum []ketamaPoint
+	for i, server := range servers {
+		name := server.Name
+		if name == "" {
+			name = server.Address
+		}
+		pct := float64(ketamaWeight(server)) / float64(total)
+		points := int(math.Floor(pct*ketamaPointsPerServer*float64(len(servers)) + 0.0000000001))
+		for index := 0; index < points/ketamaPointsPerHash; index++ {
+			digest := md5.Sum([]byte(fmt.Sprintf("%s-%d", name, index)))
+			for x := 0; x < ketamaPointsPerHash; x++ {
+				continuum = append(continuum, ketamaPoint{value: ketamaValue(digest[:], x), server: i})
+			}
+		}
+	}
+	sort.Slice(continuum, func(i, j int) bool {
+		return continuum[i].value < continuum[j].value
+	})
+	return continuum
+}
+
+func ketamaWeight(server ServerConfig) int {
+	if server.Weight == 0 {
+		return 1
+	}
+	return server.Weight
+}
+
+/*
+	the x-th little endian uint32 of a md5 digest
+ */
+func ketamaValue(digest []byte, x int) uint32 {
+	return uint32(digest[3+x*4])<<24 | uint32(digest[2+x*4])<<16 | uint32(digest[1+x*4])<<8 | uint32(digest[x*4])
+}
+
+/*
+	hash tag of ketama mode, e.g. tag "{}" hashes "user:{42}:name" as "42"
+	the whole key when there is no tag or no such non-empty part
+ */
+func (r *ketamaRouter) hashTag(key []byte) []byte {
+	if r.tag == "" {
+		return key
+	}
+	if s := bytes.IndexByte(key, r.tag[0]); s >= 0 {
+		if e := bytes.IndexByte(key[s+1:], r.tag[1]); e > 0 {
+			return key[s+1 : s+1+e]
+		}
+	}
+	return key
+}
+
+/*
+	server of a key: first point of the continuum at or after the key hash
+ */
+func (r *ketamaRouter) server(key []byte) int {
+	digest := md5.Sum(r.hashTag(key))
+	value := ketamaValue(digest[:], 0)
+	i := sort.Search(len(r.continuum), func(i int) bool {
+		return r.continuum[i].value >= value
+	})
+	if i == len(r.continuum) {
+		i = 0
+	}
+	return r.continuum[i].server
+}
+
+func (r *ketamaRouter) route(command *proxy.Command) (*redis.Pool, error) {
+	keys := commandKeys(command)
+	if len(keys) == 0 {
+		if err := keylessError(command, backendModeKetama); err != nil {
+			return nil, err
+		}
+		return r.pools[rand.Intn(len(r.pools))], nil
+	}
+	server := r.server(keys[0])
+	for _, key := range keys[1:] {
+		if r.server(key) != server {
+			return nil, redis.Error("ERR proxy: keys in request don't hash to the same server")
+		}
+	}
+	return r.pools[server], nil
+}
+
+func (r *ketamaRouter) shard(key []byte) (int, *redis.Pool, error) {
+	server := r.server(key)
+	return server, r.pools[server], nil
+}
+
+func (r *ketamaRouter) sharded() bool {
+	return true
+}
+
+func (r *ketamaRouter) redirect(err redis.Error) (*redis.Pool, bool) {
+	return nil, false
+}
+
+func (r *ketamaRouter) backends() map[string]*redis.Pool {
+	pools := make(map[string]*redis.Pool, len(r.pools))
+	for i, pool := range r.pools {
+		pools[r.addresses[i]] = pool
+	}
+	return pools
+}
+
+func (r *ketamaRouter) close() error {
+	var err error
+	for _, pool := range r.pools {
+		if e := pool.Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
@@ -0,0 +1,103 @@
+package module
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"redisProxy/proxy"
+)
+
+func newTestKetamaRouter(servers []ServerConfig, tag string) *ketamaRouter {
+	config := defaultConfig()
+	config.Backend.Mode = backendModeKetama
+	config.Backend.Servers = servers
+	config.Backend.HashTag = tag
+	return newKetamaRouter(config)
+}
+
+func TestKetamaContinuum(t *testing.T) {
+	servers := []ServerConfig{{Address: "10.0.0.1:6379", Weight: 1}, {Address: "10.0.0.2:6379", Weight: 3}}
+	continuum := ketamaContinuum(servers)
+	points := make([]int, len(servers))
+	for i, point := range continuum {
+		if i > 0 && continuum[i-1].value > point.value {
+			t.Fatalf("continuum is not sorted at %d", i)
+		}
+		points[point.server]++
+	}
+	if points[0] != 80 || points[1] != 240 {
+		t.Errorf("points = %v, want [80 240]", points)
+	}
+}
+
+func TestKetamaRouter(t *testing.T) {
+	servers := []ServerConfig{
+		{Address: "10.0.0.1:6379", Weight: 1, Name: "s1"},
+		{Address: "10.0.0.2:6379", Weight: 1, Name: "s2"},
+		{Address: "10.0.0.3:6379", Weight: 1, Name: "s3"},
+	}
+	r := newTestKetamaRouter(servers, "{}")
+	defer r.close()
+
+	counts := make([]int, len(servers))
+	for i := 0; i < 3000; i++ {
+		key := []byte(fmt.Sprintf("key:%d", i))
+		counts[r.server(key)]++
+		if tagged := []byte(fmt.Sprintf("user:{key:%d}:name", i)); r.server(tagged) != r.server(key) {
+			t.Fatalf("server(%s) != server(%s)", tagged, key)
+		}
+	}
+	for i, count := range counts {
+		if count < 600 || count > 1400 {
+			t.Errorf("server %d got %d of 3000 keys", i, count)
+		}
+	}
+
+	// 换地址不换名字，key的分布不变；去掉一个server只移动它的key
+	moved := newTestKetamaRouter([]ServerConfig{
+		{Address: "10.0.1.1:6379", Weight: 1, Name: "s1"},
+		{Address: "10.0.1.2:6379", Weight: 1, Name: "s2"},
+		{Address: "10.0.1.3:6379", Weight: 1, Name: "s3"},
+	}, "{}")
+	defer moved.close()
+	removed := newTestKetamaRouter(servers[:2], "{}")
+	defer removed.close()
+	for i := 0; i < 3000; i++ {
+		key := []byte(fmt.Sprintf("key:%d", i))
+		server := r.server(key)
+		if moved.server(key) != server {
+			t.Fatalf("server(%s) changed with the addresses", key)
+		}
+		if server != 2 && removed.server(key) != server {
+			t.Fatalf("server(%s) moved from %d to %d", key, server, removed.server(key))
+		}
+	}
+
+	a, b := []byte("{a}1"), []byte("{a}2")
+	if pool, err := r.route(newCommand("SUNION", string(a), string(b))); err != nil || pool != r.pools[r.server(a)] {
+		t.Errorf("route(SUNION %s %s) = %p, %v", a, b, pool, err)
+	}
+	for i := 0; ; i++ {
+		key := fmt.Sprintf("k%d", i)
+		if r.server([]byte(key)) != r.server(a) {
+			if _, err := r.route(newCommand("SUNION", string(a), key)); err == nil {
+				t.Errorf("route(SUNION %s %s) did not return expected error", a, key)
+			}
+			// STORE的目标key也要在同一个server
+			if _, err := r.route(newCommand("SORT", string(a), "STORE", key)); err == nil {
+				t.Errorf("route(SORT %s STORE %s) did not return expected error", a, key)
+			}
+			break
+		}
+	}
+
+	// 只有明确不访问key的命令发往任意server
+	if pool, err := r.route(newCommand("PING")); err != nil || pool == nil {
+		t.Errorf("route(PING) = %p, %v", pool, err)
+	}
+	for _, command := range []*proxy.Command{newCommand("KEYS", "*"), newCommand("RANDOMKEY"), newCommand("NEWCMD", "a"), newCommand("EVAL", "return 1", "0")} {
+		if _, err := r.route(command); err == nil || !strings.Contains(err.Error(), "has no key to route by") {
+			t.Errorf("route(%s) = %v, want an error", command.Name, err)
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package module
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"redisProxy/internal"
+	"redisProxy/proxy"
+	"redisProxy/redis"
+)
+
+/*
+	commands whose keys commandKeys finds in the arguments instead of the key positions
+ */
+var parsedKeyCommands = map[string]bool{
+	"EVAL": true, "EVALSHA": true,
+	"ZUNIONSTORE": true, "ZINTERSTORE": true, "ZDIFFSTORE": true,
+	"ZUNION": true, "ZINTER": true, "ZDIFF": true, "ZINTERCARD": true, "SINTERCARD": true,
+	"LMPOP": true, "ZMPOP": true, "BLMPOP": true, "BZMPOP": true,
+	"PUBLISH": true, "XREAD": true, "XREADGROUP": true,
+	"SORT": true, "GEORADIUS": true, "GEORADIUSBYMEMBER": true,
+}
+
+/*
+	whether commandKeys knows the keys of a command, or the command has none,
+	other commands may access any key
+ */
+func knownKeys(command *proxy.Command) bool {
+	name := command.CommandName()
+	ci := internal.LookupCommandInfo(name)
+	return ci.FirstKey != 0 || ci.Flags&internal.KeylessFlag != 0 || parsedKeyCommands[name]
+}
+
+/*
+	error of a command without keys in a sharded mode,
+	only commands that access no key may go to any node, the node of other commands is unknown
+	and KEYS, SCAN or FLUSHDB on one node would silently cover a part of the data
+ */
+func keylessError(command *proxy.Command, mode string) error {
+	name := command.CommandName()
+	if internal.LookupCommandInfo(name).Flags&internal.KeylessFlag != 0 {
+		return nil
+	}
+	return redis.Error(fmt.Sprintf("ERR proxy: command '%s' has no key to route by in %s mode", name, mode))
+}
+
+/*
+	keys of a command, positions come from internal.LookupCommandInfo
+	commands with a numkeys argument, a STREAMS keyword or a STORE option are handled here
+ */
+func commandKeys(command *proxy.Command) [][]byte {
+	args := command.Args
+	switch name := command.CommandName(); name {
+	case "EVAL", "EVALSHA":
+		// EVAL script numkeys key [key ...] arg [arg ...]
+		return numKeys(args, 1, 2)
+	case "ZUNIONSTORE", "ZINTERSTORE", "ZDIFFSTORE":
+		// ZUNIONSTORE destination numkeys key [key ...]
+		if len(args) == 0 {
+			return nil
+		}
+		return append([][]byte{args[0]}, numKeys(args, 1, 2)...)
+	case "ZUNION", "ZINTER", "ZDIFF", "ZINTERCARD", "SINTERCARD", "LMPOP", "ZMPOP":
+		// ZUNION numkeys key [key ...]
+		return numKeys(args, 0, 1)
+	case "BLMPOP", "BZMPOP":
+		// BLMPOP timeout numkeys key [key ...] LEFT|RIGHT [COUNT count]
+		return numKeys(args, 1, 2)
+	case "SORT":
+		// SORT key [BY pattern] [LIMIT offset count] [GET pattern ...] [ASC|DESC] [ALPHA] [STORE destination]
+		return storeKeys(args, 1, map[string]int{"BY": 1, "LIMIT": 2, "GET": 1})
+	case "GEORADIUS":
+		// GEORADIUS key longitude latitude radius unit [COUNT count [ANY]] ... [STORE key] [STOREDIST key]
+		return storeKeys(args, 5, map[string]int{"COUNT": 1})
+	case "GEORADIUSBYMEMBER":
+		// GEORADIUSBYMEMBER key member radius unit ...
+		return storeKeys(args, 4, map[string]int{"COUNT": 1})
+	case "PUBLISH":
+		// PUBLISH channel message，按频道路由，与SUBSCRIBE到同一节点
+		if len(args) == 0 {
+			return nil
+		}
+		return args[:1]
+	case "XREAD", "XREADGROUP":
+		// XREAD [COUNT count] [BLOCK ms] STREAMS key [key ...] id [id ...]
+		for i, arg := range args {
+			if strings.EqualFold(string(arg), "STREAMS") {
+				streams := args[i+1:]
+				return streams[:len(streams)/2]
+			}
+		}
+		return nil
+	default:
+		ci := internal.LookupCommandInfo(name)
+		if ci.FirstKey == 0 {
+			return nil
+		}
+		// 参数下标从0开始，命令名不在args中
+		first, last := ci.FirstKey-1, ci.LastKey-1
+		if ci.LastKey < 0 {
+			last = len(args) + ci.LastKey
+		}
+		if last >= len(args) {
+			last = len(args) - 1
+		}
+		var keys [][]byte
+		for i := first; i <= last; i += ci.KeyStep {
+			keys = append(keys, args[i])
+		}
+		return keys
+	}
+}
+
+/*
+	the key at args[0] and the destinations of STORE and STOREDIST options,
+	the options start at args[first], skip tells the number of values of other options
+ */
+func storeKeys(args [][]byte, first int, skip map[string]int) [][]byte {
+	if len(args) == 0 {
+		return nil
+	}
+	keys := [][]byte{args[0]}
+	for i := first; i < len(args); i++ {
+		option := strings.ToUpper(string(args[i]))
+		if option == "STORE" || option == "STOREDIST" {
+			if i+1 < len(args) {
+				keys = append(keys, args[i+1])
+			}
+			i++
+			continue
+		}
+		i += skip[option]
+	}
+	return keys
+}
+
+/*
+	keys following a numkeys argument at args[n], the first key is at args[first]
+ */
+func numKeys(args [][]byte, n, first int) [][]byte {
+	if len(args) <= n {
+		return nil
+	}
+	count, err := strconv.Atoi(string(args[n]))
+	if err != nil || count < 0 || first+count > len(args) {
+		return nil
+	}
+	return args[first : first+count]
+}
+
+/*
+	hash tag of a key: the part between the first { and the following },
+	the whole key when there is no such non-empty part
+ */
+func hashTag(key []byte) []byte {
+	if s := bytes.IndexByte(key, '{'); s >= 0 {
+		if e := bytes.IndexByte(key[s+1:], '}'); e > 0 {
+			return key[s+1 : s+1+e]
+		}
+	}
+	return key
+}
@@ -0,0 +1,283 @@
+package module
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"redisProxy/proxy"
+	"redisProxy/redis"
+)
+
+/*
+	prometheus metrics in the text exposition format, served on Config.Metrics.Listen at /metrics
+	command labels are capped at maxCommandLabels names, further names are counted as "other"
+ */
+
+const maxCommandLabels = 512
+
+// 命令耗时直方图的上界，单位秒
+var latencyBuckets = []float64{.0001, .00025, .0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+type metrics struct {
+	mu		sync.Mutex
+	commands	map[string]*commandMetrics
+	filtered	map[string]int64
+	accepted	int64
+	protocolErrors	int64
+}
+
+/*
+	calls and latency histogram of a command, counts[i] is the number of calls within latencyBuckets[i]
+ */
+type commandMetrics struct {
+	calls	int64
+	sum	time.Duration
+	counts	[]int64
+}
+
+/*
+	label of a command name, names not seen yet become "other" once there are too many series
+ */
+func commandLabel(name string, seen bool, series int) string {
+	if seen || series < maxCommandLabels {
+		return name
+	}
+	return "other"
+}
+
+/*
+	record the commands of a batch, their replies were written together after d
+ */
+func (m *metrics) observe(commands []*proxy.Command, d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.commands == nil {
+		m.commands = make(map[string]*commandMetrics)
+	}
+	for _, command := range commands {
+		name := command.CommandName()
+		name = commandLabel(name, m.commands[name] != nil, len(m.commands))
+		cm := m.commands[name]
+		if cm == nil {
+			cm = &commandMetrics{counts: make([]int64, len(latencyBuckets))}
+			m.commands[name] = cm
+		}
+		cm.calls++
+		cm.sum += d
+		for i, bound := range latencyBuckets {
+			if d.Seconds() <= bound {
+				cm.counts[i]++
+			}
+		}
+	}
+}
+
+func (m *metrics) filter(command *proxy.Command) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.filtered == nil {
+		m.filtered = make(map[string]int64)
+	}
+	name := command.CommandName()
+	_, seen := m.filtered[name]
+	m.filtered[commandLabel(name, seen, len(m.filtered))]++
+}
+
+/*
+	totals over all commands
+ */
+func (m *metrics) totals() (commands, filtered, accepted, protocolErrors int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, cm := range m.commands {
+		commands += cm.calls
+	}
+	for _, n := range m.filtered {
+		filtered += n
+	}
+	return commands, filtered, m.accepted, m.protocolErrors
+}
+
+func (m *metrics) accept() {
+	m.mu.Lock()
+	m.accepted++
+	m.mu.Unlock()
+}
+
+func (m *metrics) protocolError() {
+	m.mu.Lock()
+	m.protocolErrors++
+	m.mu.Unlock()
+}
+
+/*
+	filter check counting the rejected commands
+ */
+func (server *Server) filterCheck(command *proxy.Command) error {
+	err := server.filter.check(command)
+	if err != nil {
+		server.metrics.filter(command)
+	}
+	return err
+}
+
+/*
+	http handler of /metrics
+ */
+func (server *Server) MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		server.writeMetrics(w)
+	})
+}
+
+/*
+	serve /metrics until Shutdown
+ */
+func (server *Server) serveMetrics(address string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", server.MetricsHandler())
+	hs := &http.Server{Addr: address, Handler: mux}
+	server.mu.Lock()
+	if server.closing {
+		server.mu.Unlock()
+		return
+	}
+	server.metricsServer = hs
+	server.mu.Unlock()
+	if err := hs.ListenAndServe(); err != http.ErrServerClosed {
+		server.log.Error("metrics listener failed", "listen", address, "err", err)
+	}
+}
+
+func (server *Server) writeMetrics(out io.Writer) error {
+	w := bufio.NewWriter(out)
+	m := &server.metrics
+
+	server.mu.Lock()
+	var active, idle int
+	for _, a := range server.clients {
+		if a {
+			active++
+		} else {
+			idle++
+		}
+	}
+	server.mu.Unlock()
+	writeHeader(w, "redisproxy_client_connections", "gauge", "Client connections by state.")
+	fmt.Fprintf(w, "redisproxy_client_connections{state=\"active\"} %d\n", active)
+	fmt.Fprintf(w, "redisproxy_client_connections{state=\"idle\"} %d\n", idle)
+
+	m.mu.Lock()
+	writeHeader(w, "redisproxy_client_connections_total", "counter", "Client connections accepted.")
+	fmt.Fprintf(w, "redisproxy_client_connections_total %d\n", m.accepted)
+	writeHeader(w, "redisproxy_protocol_errors_total", "counter", "Client connections closed on a protocol error.")
+	fmt.Fprintf(w, "redisproxy_protocol_errors_total %d\n", m.protocolErrors)
+
+	writeHeader(w, "redisproxy_filtered_commands_total", "counter", "Commands rejected by the filter.")
+	filtered := make([]string, 0, len(m.filtered))
+	for name := range m.filtered {
+		filtered = append(filtered, name)
+	}
+	sort.Strings(filtered)
+	for _, name := range filtered {
+		fmt.Fprintf(w, "redisproxy_filtered_commands_total{command=\"%s\"} %d\n", escapeLabel(name), m.filtered[name])
+	}
+
+	names := make([]string, 0, len(m.commands))
+	for name := range m.commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	writeHeader(w, "redisproxy_commands_total", "counter", "Commands run by clients.")
+	for _, name := range names {
+		fmt.Fprintf(w, "redisproxy_commands_total{command=\"%s\"} %d\n", escapeLabel(name), m.commands[name].calls)
+	}
+	writeHeader(w, "redisproxy_command_duration_seconds", "histogram", "Time from reading a command to writing its reply.")
+	for _, name := range names {
+		cm, label := m.commands[name], escapeLabel(name)
+		for i, bound := range latencyBuckets {
+			fmt.Fprintf(w, "redisproxy_command_duration_seconds_bucket{command=\"%s\",le=\"%g\"} %d\n", label, bound, cm.counts[i])
+		}
+		fmt.Fprintf(w, "redisproxy_command_duration_seconds_bucket{command=\"%s\",le=\"+Inf\"} %d\n", label, cm.calls)
+		fmt.Fprintf(w, "redisproxy_command_duration_seconds_sum{command=\"%s\"} %g\n", label, cm.sum.Seconds())
+		fmt.Fprintf(w, "redisproxy_command_duration_seconds_count{command=\"%s\"} %d\n", label, cm.calls)
+	}
+	m.mu.Unlock()
+
+	server.writePoolMetrics(w)
+	return w.Flush()
+}
+
+/*
+	stats of a backend pool
+ */
+type backendStats struct {
+	address, role	string
+	stats		redis.PoolStats
+}
+
+/*
+	stats of the master pools sorted by address, then of the replicas
+ */
+func (server *Server) backendStats() []backendStats {
+	var backends []backendStats
+	server.configMu.RLock()
+	defer server.configMu.RUnlock()
+	for address, pool := range server.router.backends() {
+		backends = append(backends, backendStats{address, "master", pool.Stats()})
+	}
+	sort.Slice(backends, func(i, j int) bool { return backends[i].address < backends[j].address })
+	if server.replicas != nil {
+		for i, pool := range server.replicas.pools {
+			backends = append(backends, backendStats{server.replicas.addresses[i], "replica", pool.Stats()})
+		}
+	}
+	return backends
+}
+
+/*
+	stats of the backend pools, labeled by address and role
+ */
+func (server *Server) writePoolMetrics(w io.Writer) {
+	backends := server.backendStats()
+
+	series := []struct {
+		name, kind, help	string
+		value			func(redis.PoolStats) string
+	}{
+		{"redisproxy_backend_active_connections", "gauge", "Backend connections in use or idle.",
+			func(s redis.PoolStats) string { return fmt.Sprint(s.ActiveCount) }},
+		{"redisproxy_backend_idle_connections", "gauge", "Idle backend connections.",
+			func(s redis.PoolStats) string { return fmt.Sprint(s.IdleCount) }},
+		{"redisproxy_backend_waits_total", "counter", "Times a command waited for a backend connection.",
+			func(s redis.PoolStats) string { return fmt.Sprint(s.WaitCount) }},
+		{"redisproxy_backend_wait_seconds_total", "counter", "Time spent waiting for backend connections.",
+			func(s redis.PoolStats) string { return fmt.Sprintf("%g", s.WaitDuration.Seconds()) }},
+		{"redisproxy_backend_dial_errors_total", "counter", "Failed backend dials.",
+			func(s redis.PoolStats) string { return fmt.Sprint(s.DialErrors) }},
+		{"redisproxy_backend_test_failures_total", "counter", "Idle backend connections that failed the health check.",
+			func(s redis.PoolStats) string { return fmt.Sprint(s.TestFailures) }},
+	}
+	for _, s := range series {
+		writeHeader(w, s.name, s.kind, s.help)
+		for _, b := range backends {
+			fmt.Fprintf(w, "%s{backend=\"%s\",role=\"%s\"} %s\n", s.name, escapeLabel(b.address), b.role, s.value(b.stats))
+		}
+	}
+}
+
+func writeHeader(w io.Writer, name, kind, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(s string) string {
+	return labelEscaper.Replace(s)
+}
@@ -0,0 +1,73 @@
+package module
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"redisProxy/proxy"
+	"redisProxy/redis"
+)
+
+func TestServer_metrics(t *testing.T) {
+	f, _ := newFilter(defaultFilterConfig())
+	broken := &redis.Pool{Dial: func() (redis.Conn, error) { return nil, errors.New("connection refused") }}
+	server := &Server{
+		config: &Config{},
+		filter: f,
+		router: &singleRouter{address: "127.0.0.1:6379", pool: &redis.Pool{Dial: func() (redis.Conn, error) { return &echoConn{}, nil }, MaxIdle: 1}},
+		replicas: &replicaSet{pools: []*redis.Pool{broken}, addresses: []string{"127.0.0.1:6380"}, weights: []int{1}},
+	}
+	server.pubsub = newPubsub(server)
+
+	var buf bytes.Buffer
+	client := &Client{
+		reader: bufio.NewReader(strings.NewReader("GET a\r\nGET b\r\nKEYS *\r\nINCR \"a\\\"b\"\r\n")),
+		writer: proxy.NewWriter(&buf, 4096),
+	}
+	commands, err := client.readPipeline()
+	if err != nil {
+		t.Fatalf("readPipeline() returned error %v", err)
+	}
+	commands[3].Name = []byte("IN\"CR")
+	if err := server.execute(client, commands); err != nil {
+		t.Fatalf("execute() returned error %v", err)
+	}
+
+	w := httptest.NewRecorder()
+	server.MetricsHandler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	body := w.Body.String()
+	for _, line := range []string{
+		"# TYPE redisproxy_commands_total counter",
+		`redisproxy_commands_total{command="GET"} 2`,
+		`redisproxy_commands_total{command="IN\"CR"} 1`,
+		`redisproxy_command_duration_seconds_bucket{command="GET",le="+Inf"} 2`,
+		`redisproxy_command_duration_seconds_count{command="KEYS"} 1`,
+		`redisproxy_filtered_commands_total{command="KEYS"} 1`,
+		`redisproxy_backend_active_connections{backend="127.0.0.1:6379",role="master"} 1`,
+		`redisproxy_backend_idle_connections{backend="127.0.0.1:6379",role="master"} 1`,
+		// GET先尝试从库，拨号失败后读主库
+		`redisproxy_backend_dial_errors_total{backend="127.0.0.1:6380",role="replica"} 2`,
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("metrics do not contain %q", line)
+		}
+	}
+	if t.Failed() {
+		t.Log(body)
+	}
+}
+
+func TestCommandLabel(t *testing.T) {
+	if label := commandLabel("GET", false, maxCommandLabels-1); label != "GET" {
+		t.Errorf("commandLabel() = %q, want GET", label)
+	}
+	if label := commandLabel("GET", true, maxCommandLabels); label != "GET" {
+		t.Errorf("commandLabel() of a seen name = %q, want GET", label)
+	}
+	if label := commandLabel("XYZ", false, maxCommandLabels); label != "other" {
+		t.Errorf("commandLabel() past the cap = %q, want other", label)
+	}
+}
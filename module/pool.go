@@ -0,0 +1,30 @@
+package module
+
+import (
+	"time"
+	"redisProxy/redis"
+)
+
+/*
+	backend connection pool shared by all clients
+ */
+func newPool(address string, config *Config) *redis.Pool {
+	backend := config.Backend
+	return &redis.Pool{
+		Dial: func() (redis.Conn, error) {
+			return redis.Dial("tcp", address, backend.dialOptions()...)
+		},
+		// 空闲超过一分钟的连接在使用前先PING检测
+		TestOnBorrow: func(c redis.Conn, t time.Time) error {
+			if time.Since(t) < time.Minute {
+				return nil
+			}
+			_, err := c.Do("PING")
+			return err
+		},
+		MaxIdle: config.Pool.MaxIdle,
+		MaxActive: config.Pool.MaxActive,
+		IdleTimeout: config.Pool.IdleTimeout.value(),
+		Wait: config.Pool.Wait,
+	}
+}
@@ -0,0 +1,387 @@
+package module
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"redisProxy/proxy"
+	"redisProxy/redis"
+)
+
+/*
+	pub/sub proxying
+	clients subscribed through the proxy share one subscriber connection per backend pool,
+	a channel is subscribed on the backend by its first client and unsubscribed by its last,
+	messages are pushed to every client subscribed to the channel or pattern,
+	through a queue per client so that a slow client does not delay the others,
+	a client whose queue is full is disconnected
+ */
+
+const (
+	subscribeChannel	= "channel"
+	subscribePattern	= "pattern"
+
+	pushQueueLen	= 1024
+)
+
+type pubsub struct {
+	server		*Server
+	mu		sync.Mutex
+	subscribers	map[*redis.Pool]*subscriber
+}
+
+/*
+	backend subscriber connection and the clients of its channels and patterns
+ */
+type subscriber struct {
+	pool		*redis.Pool
+	conn		redis.Conn
+	channels	map[string]map[*Client]bool
+	patterns	map[string]map[*Client]bool
+}
+
+func newPubsub(server *Server) *pubsub {
+	return &pubsub{
+		server: server,
+		subscribers: make(map[*redis.Pool]*subscriber),
+	}
+}
+
+/*
+	commands handled by the pub/sub proxy instead of the pipeline
+ */
+func (ps *pubsub) handles(client *Client, command *proxy.Command) bool {
+	switch command.CommandName() {
+	case "SUBSCRIBE", "PSUBSCRIBE", "UNSUBSCRIBE", "PUNSUBSCRIBE":
+		return true
+	}
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	return client.subscriptions() > 0
+}
+
+/*
+	execute a pub/sub command, replies are written under the client lock
+	as pushed messages may be written at the same time
+ */
+func (ps *pubsub) execute(client *Client, command *proxy.Command) error {
+	var replies []interface{}
+	name := command.CommandName()
+	if err := ps.server.check(client, command); err != nil {
+		replies = append(replies, err)
+	} else {
+		switch name {
+		case "SUBSCRIBE", "PSUBSCRIBE":
+			kind := subscribeChannel
+			if name == "PSUBSCRIBE" {
+				kind = subscribePattern
+			}
+			if len(command.Args) == 0 {
+				replies = append(replies, redis.Error(fmt.Sprintf("ERR wrong number of arguments for '%s' command", strings.ToLower(name))))
+			}
+			for _, arg := range command.Args {
+				replies = append(replies, ps.subscribe(client, kind, string(arg)))
+			}
+		case "UNSUBSCRIBE":
+			replies = ps.unsubscribe(client, subscribeChannel, command.Args)
+		case "PUNSUBSCRIBE":
+			replies = ps.unsubscribe(client, subscribePattern, command.Args)
+		case "PING":
+			// 与redis相同，没有参数时回复空字符串
+			message := []byte{}
+			if len(command.Args) > 0 {
+				message = command.Args[0]
+			}
+			replies = append(replies, []interface{}{[]byte("pong"), message})
+		case "QUIT":
+			client.write("OK")
+			return fmt.Errorf("client quit")
+		default:
+			replies = append(replies, redis.Error(fmt.Sprintf("ERR Can't execute '%s': only (P)SUBSCRIBE / (P)UNSUBSCRIBE / PING / QUIT are allowed in this context", strings.ToLower(name))))
+		}
+	}
+	return client.write(replies...)
+}
+
+/*
+	subscribe a client, the backend subscription is shared by all clients of the channel
+ */
+func (ps *pubsub) subscribe(client *Client, kind, name string) interface{} {
+	pool, err := ps.pool(kind, name)
+	if err != nil {
+		return err
+	}
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	subscriptions := client.subscribed(kind)
+	if _, ok := subscriptions[name]; !ok {
+		s, err := ps.subscriber(pool)
+		if err != nil {
+			return err
+		}
+		clients := s.clients(kind)
+		if clients[name] == nil {
+			clients[name] = make(map[*Client]bool)
+			s.conn.Send(strings.ToUpper(subscribeCommand(kind)), name)
+			if err := s.conn.Flush(); err != nil {
+				delete(clients, name)
+				return redis.Error("ERR proxy: " + err.Error())
+			}
+		}
+		clients[name][client] = true
+		subscriptions[name] = s
+		if client.messages == nil {
+			client.messages = make(chan []interface{}, pushQueueLen)
+			go pushLoop(client, client.messages)
+		}
+	}
+	return []interface{}{[]byte(subscribeCommand(kind)), []byte(name), int64(client.subscriptions())}
+}
+
+/*
+	unsubscribe a client from the names, from all its channels or patterns when names is empty
+ */
+func (ps *pubsub) unsubscribe(client *Client, kind string, names [][]byte) []interface{} {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	reply := []byte(unsubscribeCommand(kind))
+	subscriptions := client.subscribed(kind)
+	if len(names) == 0 {
+		for name := range subscriptions {
+			names = append(names, []byte(name))
+		}
+		if len(names) == 0 {
+			return []interface{}{[]interface{}{reply, nil, int64(client.subscriptions())}}
+		}
+	}
+	var replies []interface{}
+	for _, name := range names {
+		ps.remove(client, kind, string(name))
+		replies = append(replies, []interface{}{reply, name, int64(client.subscriptions())})
+	}
+	return replies
+}
+
+/*
+	remove all subscriptions of a closed client
+ */
+func (ps *pubsub) close(client *Client) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	for _, kind := range []string{subscribeChannel, subscribePattern} {
+		for name := range client.subscribed(kind) {
+			ps.remove(client, kind, name)
+		}
+	}
+}
+
+/*
+	remove a subscription of a client, the caller holds ps.mu
+	the last client of a channel unsubscribes it on the backend,
+	the subscriber connection is released by its receive loop once it has no subscription
+ */
+func (ps *pubsub) remove(client *Client, kind, name string) {
+	subscriptions := client.subscribed(kind)
+	s, ok := subscriptions[name]
+	if !ok {
+		return
+	}
+	delete(subscriptions, name)
+	stopPush(client)
+	clients := s.clients(kind)
+	delete(clients[name], client)
+	if len(clients[name]) == 0 {
+		delete(clients, name)
+		s.conn.Send(strings.ToUpper(unsubscribeCommand(kind)), name)
+		s.conn.Flush()
+	}
+}
+
+/*
+	shared subscriber connection of a pool, the caller holds ps.mu
+ */
+func (ps *pubsub) subscriber(pool *redis.Pool) (*subscriber, error) {
+	if s, ok := ps.subscribers[pool]; ok {
+		return s, nil
+	}
+	c := pool.Get()
+	if err := c.Err(); err != nil {
+		c.Close()
+		return nil, redis.Error("ERR proxy: " + err.Error())
+	}
+	s := &subscriber{
+		pool: pool,
+		conn: c,
+		channels: make(map[string]map[*Client]bool),
+		patterns: make(map[string]map[*Client]bool),
+	}
+	ps.subscribers[pool] = s
+	go ps.receive(s)
+	return s, nil
+}
+
+/*
+	backend pool of a channel or pattern
+	channels are routed like keys so that PUBLISH and SUBSCRIBE of a channel meet on one node,
+	patterns go to any node as redis cluster broadcasts messages to every node,
+	they are rejected in ketama mode where a pattern would only see the channels of one server
+ */
+func (ps *pubsub) pool(kind, name string) (*redis.Pool, error) {
+	ps.server.configMu.RLock()
+	defer ps.server.configMu.RUnlock()
+	if kind == subscribeChannel {
+		_, pool, err := ps.server.router.shard([]byte(name))
+		return pool, err
+	}
+	if ps.server.config.Backend.Mode == backendModeKetama {
+		return nil, redis.Error("ERR proxy: PSUBSCRIBE is not supported in ketama mode")
+	}
+	return ps.server.router.route(&proxy.Command{Name: []byte("PSUBSCRIBE")})
+}
+
+/*
+	receive loop of a subscriber connection
+	messages are pushed to the clients, subscription counts are the proxy's own
+ */
+func (ps *pubsub) receive(s *subscriber) {
+	for {
+		// 订阅连接可能长时间没有消息，不使用backend.read_timeout
+		reply, err := redis.Values(redis.ReceiveWithTimeout(s.conn, 0))
+		if err != nil {
+			ps.broken(s, err)
+			return
+		}
+		if len(reply) < 3 {
+			continue
+		}
+		kind, _ := redis.String(reply[0], nil)
+		name, _ := redis.String(reply[1], nil)
+		switch kind {
+		case "message":
+			ps.push(s, subscribeChannel, name, reply)
+		case "pmessage":
+			ps.push(s, subscribePattern, name, reply)
+		case "unsubscribe", "punsubscribe":
+			if count, _ := redis.Int(reply[2], nil); count == 0 && ps.release(s) {
+				return
+			}
+		}
+	}
+}
+
+/*
+	queue a message to the clients of a channel or pattern, without waiting for their writes
+ */
+func (ps *pubsub) push(s *subscriber, kind, name string, message []interface{}) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	for client := range s.clients(kind)[name] {
+		if client.messages == nil {
+			// 已经因为太慢被关闭
+			continue
+		}
+		select {
+		case client.messages <- message:
+		default:
+			client.log.Warn("pub/sub client too slow, closing the client connection", "queued", pushQueueLen)
+			close(client.messages)
+			client.messages = nil
+			client.conn.Close()
+		}
+	}
+}
+
+/*
+	write the queued messages of a client until the queue is closed by stopPush
+ */
+func pushLoop(client *Client, messages chan []interface{}) {
+	failed := false
+	for message := range messages {
+		if failed {
+			continue
+		}
+		if err := client.write(message); err != nil {
+			failed = true
+			client.conn.Close()
+		}
+	}
+}
+
+/*
+	close the message queue of a client without subscriptions, the caller holds ps.mu
+ */
+func stopPush(client *Client) {
+	if client.subscriptions() == 0 && client.messages != nil {
+		close(client.messages)
+		client.messages = nil
+	}
+}
+
+/*
+	release a subscriber without subscriptions, pooledConnection.Close
+	unsubscribes and drains the connection before returning it to the pool
+ */
+func (ps *pubsub) release(s *subscriber) bool {
+	ps.mu.Lock()
+	if len(s.channels) != 0 || len(s.patterns) != 0 {
+		// 新的订阅仍在使用该连接
+		ps.mu.Unlock()
+		return false
+	}
+	delete(ps.subscribers, s.pool)
+	ps.mu.Unlock()
+	s.conn.Close()
+	return true
+}
+
+/*
+	the subscriber connection failed, its clients are disconnected so that they resubscribe
+ */
+func (ps *pubsub) broken(s *subscriber, err error) {
+	ps.server.log.Warn("subscriber connection failed, closing its clients", "backend", ps.server.node(s.pool), "err", err)
+	ps.mu.Lock()
+	if ps.subscribers[s.pool] == s {
+		delete(ps.subscribers, s.pool)
+	}
+	clients := make(map[*Client]bool)
+	for _, kind := range []string{subscribeChannel, subscribePattern} {
+		for name, subscribed := range s.clients(kind) {
+			for client := range subscribed {
+				delete(client.subscribed(kind), name)
+				stopPush(client)
+				clients[client] = true
+			}
+		}
+	}
+	s.channels = make(map[string]map[*Client]bool)
+	s.patterns = make(map[string]map[*Client]bool)
+	ps.mu.Unlock()
+	s.conn.Close()
+	for client := range clients {
+		client.conn.Close()
+	}
+}
+
+func (s *subscriber) clients(kind string) map[string]map[*Client]bool {
+	if kind == subscribePattern {
+		return s.patterns
+	}
+	return s.channels
+}
+
+/*
+	subscribe and unsubscribe command of a kind, also the kind of their replies
+ */
+func subscribeCommand(kind string) string {
+	if kind == subscribePattern {
+		return "psubscribe"
+	}
+	return "subscribe"
+}
+
+func unsubscribeCommand(kind string) string {
+	if kind == subscribePattern {
+		return "punsubscribe"
+	}
+	return "unsubscribe"
+}
@@ -0,0 +1,209 @@
+package module
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"net"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"redisProxy/proxy"
+	"redisProxy/redis"
+)
+
+/*
+	fake subscriber connection, replies and messages are queued on a channel
+ */
+type subscriberConn struct {
+	mu      sync.Mutex
+	sent    []string
+	count   int
+	replies chan interface{}
+}
+
+func (c *subscriberConn) Close() error { close(c.replies); return nil }
+func (c *subscriberConn) Err() error   { return nil }
+func (c *subscriberConn) Flush() error { return nil }
+
+func (c *subscriberConn) Do(string, ...interface{}) (interface{}, error) { return nil, nil }
+
+func (c *subscriberConn) Send(commandName string, args ...interface{}) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if commandName == "ECHO" {
+		c.replies <- args[0]
+		return nil
+	}
+	if len(args) == 0 {
+		// pooledConnection.Close
+		c.replies <- []interface{}{[]byte(strings.ToLower(commandName)), nil, int64(0)}
+		return nil
+	}
+	c.sent = append(c.sent, commandName+" "+args[0].(string))
+	if strings.HasPrefix(commandName, "UN") || strings.HasPrefix(commandName, "PUN") {
+		c.count--
+	} else {
+		c.count++
+	}
+	c.replies <- []interface{}{[]byte(strings.ToLower(commandName)), []byte(args[0].(string)), int64(c.count)}
+	return nil
+}
+
+func (c *subscriberConn) Receive() (interface{}, error) {
+	reply, ok := <-c.replies
+	if !ok {
+		return nil, errors.New("closed")
+	}
+	return reply, nil
+}
+
+func (c *subscriberConn) ReceiveWithTimeout(timeout time.Duration) (interface{}, error) {
+	if timeout != 0 {
+		return nil, errors.New("subscriber connection read with a timeout")
+	}
+	return c.Receive()
+}
+
+func (c *subscriberConn) commands() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return strings.Join(c.sent, ", ")
+}
+
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestPubsub(t *testing.T) {
+	c := &subscriberConn{replies: make(chan interface{}, 16)}
+	f, _ := newFilter(defaultFilterConfig())
+	server := &Server{
+		filter: f,
+		router: &singleRouter{pool: &redis.Pool{Dial: func() (redis.Conn, error) { return c, nil }}},
+	}
+	server.pubsub = newPubsub(server)
+	var bufs [2]lockedBuffer
+	clients := [2]*Client{{writer: proxy.NewWriter(&bufs[0], 4096)}, {writer: proxy.NewWriter(&bufs[1], 4096)}}
+
+	for _, client := range clients {
+		if err := server.pubsub.execute(client, newCommand("SUBSCRIBE", "news")); err != nil {
+			t.Fatalf("SUBSCRIBE returned error %v", err)
+		}
+	}
+	if sent := c.commands(); sent != "SUBSCRIBE news" {
+		t.Errorf("backend got %q, want one SUBSCRIBE news", sent)
+	}
+	if !server.pubsub.handles(clients[0], newCommand("GET", "a")) {
+		t.Errorf("handles(GET) = false for a subscribed client")
+	}
+
+	c.replies <- []interface{}{[]byte("message"), []byte("news"), []byte("hello")}
+	message := "*3\r\n$7\r\nmessage\r\n$4\r\nnews\r\n$5\r\nhello\r\n"
+	subscribed := "*3\r\n$9\r\nsubscribe\r\n$4\r\nnews\r\n:1\r\n"
+	for i := range clients {
+		for j := 0; j < 1000 && bufs[i].String() != subscribed+message; j++ {
+			runtime.Gosched()
+		}
+		if bufs[i].String() != subscribed+message {
+			t.Errorf("client %d got %q, want %q", i, bufs[i].String(), subscribed+message)
+		}
+	}
+
+	server.pubsub.execute(clients[0], newCommand("UNSUBSCRIBE"))
+	server.pubsub.close(clients[1])
+	if sent := c.commands(); sent != "SUBSCRIBE news, UNSUBSCRIBE news" {
+		t.Errorf("backend got %q, want SUBSCRIBE and UNSUBSCRIBE news", sent)
+	}
+	// 最后一个订阅取消后，接收循环释放订阅连接
+	for j := 0; j < 1000; j++ {
+		server.pubsub.mu.Lock()
+		n := len(server.pubsub.subscribers)
+		server.pubsub.mu.Unlock()
+		if n == 0 {
+			break
+		}
+		runtime.Gosched()
+	}
+	if len(server.pubsub.subscribers) != 0 || server.pubsub.handles(clients[0], newCommand("GET", "a")) {
+		t.Errorf("subscriber was not released")
+	}
+}
+
+func TestPubsub_slowClient(t *testing.T) {
+	c := &subscriberConn{replies: make(chan interface{}, 16)}
+	f, _ := newFilter(defaultFilterConfig())
+	server := &Server{
+		filter: f,
+		router: &singleRouter{pool: &redis.Pool{Dial: func() (redis.Conn, error) { return c, nil }}},
+	}
+	server.pubsub = newPubsub(server)
+	// 没有读取的net.Pipe，写入一直阻塞
+	slowConn, peer := net.Pipe()
+	defer peer.Close()
+	var buf lockedBuffer
+	slow := &Client{conn: slowConn, writer: proxy.NewWriter(slowConn, 4096)}
+	fast := &Client{writer: proxy.NewWriter(&buf, 4096)}
+	server.pubsub.subscribe(slow, subscribeChannel, "news")
+	server.pubsub.subscribe(fast, subscribeChannel, "news")
+
+	// 每条消息都送达快的客户端后再发送下一条
+	message := "*3\r\n$7\r\nmessage\r\n$4\r\nnews\r\n$5\r\nhello\r\n"
+	deadline := time.Now().Add(5 * time.Second)
+	for i := 1; i <= pushQueueLen+100; i++ {
+		c.replies <- []interface{}{[]byte("message"), []byte("news"), []byte("hello")}
+		for strings.Count(buf.String(), message) < i && time.Now().Before(deadline) {
+			runtime.Gosched()
+		}
+		if got := strings.Count(buf.String(), message); got != i {
+			t.Fatalf("fast client got %d messages, want %d", got, i)
+		}
+	}
+	// 队列满的客户端连接被关闭
+	peer.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := ioutil.ReadAll(peer); err != nil {
+		t.Errorf("slow client connection was not closed: %v", err)
+	}
+}
+
+func TestPubsub_ping(t *testing.T) {
+	f, _ := newFilter(defaultFilterConfig())
+	server := &Server{filter: f}
+	server.pubsub = newPubsub(server)
+	var buf lockedBuffer
+	client := &Client{writer: proxy.NewWriter(&buf, 4096), channels: map[string]*subscriber{"news": nil}}
+	server.pubsub.execute(client, newCommand("PING"))
+	server.pubsub.execute(client, newCommand("PING", "hi"))
+	if expected := "*2\r\n$4\r\npong\r\n$0\r\n\r\n*2\r\n$4\r\npong\r\n$2\r\nhi\r\n"; buf.String() != expected {
+		t.Errorf("PING in subscribe mode wrote %q, want %q", buf.String(), expected)
+	}
+}
+
+func TestPubsub_ketamaPattern(t *testing.T) {
+	r := newTestKetamaRouter([]ServerConfig{{Address: "10.0.0.1:6379", Weight: 1}, {Address: "10.0.0.2:6379", Weight: 1}}, "")
+	defer r.close()
+	f, _ := newFilter(defaultFilterConfig())
+	server := &Server{filter: f, router: r, config: &Config{Backend: BackendConfig{Mode: backendModeKetama}}}
+	server.pubsub = newPubsub(server)
+	var buf lockedBuffer
+	client := &Client{writer: proxy.NewWriter(&buf, 4096)}
+	server.pubsub.execute(client, newCommand("PSUBSCRIBE", "news.*"))
+	if !strings.HasPrefix(buf.String(), "-ERR proxy: PSUBSCRIBE is not supported in ketama mode") || client.subscriptions() != 0 {
+		t.Errorf("PSUBSCRIBE in ketama mode wrote %q", buf.String())
+	}
+}
@@ -0,0 +1,107 @@
+package module
+
+import (
+	"fmt"
+)
+
+/*
+	hot config reload
+	the filter, users, backends, pools and timeouts of the new config apply to the commands
+	run after Reload returns, client connections stay open,
+	the old backend pools are closed and their connections closed as they are released
+	the listen address, its TLS certificate, the metrics address and the log config need a restart
+ */
+
+/*
+	swap in a new config, the old one stays in use when the new one is invalid
+ */
+func (server *Server) Reload(config *Config) error {
+	server.reloadMu.Lock()
+	defer server.reloadMu.Unlock()
+	return server.reload(config)
+}
+
+func (server *Server) reload(config *Config) error {
+	f, err := newFilter(config.Filter)
+	if err != nil {
+		return err
+	}
+	a, err := newACL(config.Users)
+	if err != nil {
+		return err
+	}
+	r, err := newRouter(config, server.log)
+	if err != nil {
+		return err
+	}
+	replicas := newReplicaSet(config)
+
+	server.configMu.Lock()
+	old := server.config
+	oldRouter, oldReplicas := server.router, server.replicas
+	server.config = config
+	server.filter = f
+	server.acl = a
+	server.router = r
+	server.replicas = replicas
+	server.configMu.Unlock()
+
+	// 批次在读锁内执行，此时旧连接池上没有正在执行的批次
+	oldRouter.close()
+	if oldReplicas != nil {
+		oldReplicas.close()
+	}
+	server.log.Info("config reloaded", "path", config.path)
+	if config.Listen != old.Listen || config.TLS.CertFile != old.TLS.CertFile ||
+		config.TLS.KeyFile != old.TLS.KeyFile || config.TLS.ClientCAFile != old.TLS.ClientCAFile ||
+		config.Metrics.Listen != old.Metrics.Listen || config.Log != old.Log {
+		server.log.Warn("listen, tls, metrics and log changes apply after a restart")
+	}
+	return nil
+}
+
+/*
+	reload the config file the server config was loaded from
+ */
+func (server *Server) ReloadFile() error {
+	server.configMu.RLock()
+	path := server.config.path
+	server.configMu.RUnlock()
+	if path == "" {
+		return fmt.Errorf("config: not loaded from a file")
+	}
+	config, err := LoadConfig(path)
+	if err != nil {
+		return err
+	}
+	return server.Reload(config)
+}
+
+/*
+	reload only the command filter from the config file
+ */
+func (server *Server) reloadFilter() error {
+	server.reloadMu.Lock()
+	defer server.reloadMu.Unlock()
+	server.configMu.RLock()
+	path := server.config.path
+	server.configMu.RUnlock()
+	if path == "" {
+		return fmt.Errorf("config: not loaded from a file")
+	}
+	loaded, err := LoadConfig(path)
+	if err != nil {
+		return err
+	}
+	f, err := newFilter(loaded.Filter)
+	if err != nil {
+		return err
+	}
+	server.configMu.Lock()
+	config := *server.config
+	config.Filter = loaded.Filter
+	server.config = &config
+	server.filter = f
+	server.configMu.Unlock()
+	return nil
+}
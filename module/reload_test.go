@@ -0,0 +1,93 @@
+package module
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"redisProxy/proxy"
+)
+
+func TestServer_Reload(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "redisProxy.json")
+	write := func(config string) {
+		if err := ioutil.WriteFile(path, []byte(config), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(`{"backend": {"addresses": ["127.0.0.1:1"]}, "filter": {"deny": ["KEYS"]}, "admin": {"enabled": true}}`)
+	config, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("LoadConfig() returned error %v", err)
+	}
+	server, err := NewServer(config)
+	if err != nil {
+		t.Fatalf("NewServer() returned error %v", err)
+	}
+	old := server.router.(*singleRouter).pool
+
+	run := func(input string) string {
+		var buf bytes.Buffer
+		client := &Client{
+			server: server,
+			reader: bufio.NewReader(strings.NewReader(input)),
+			writer: proxy.NewWriter(&buf, 4096),
+		}
+		commands, err := client.readPipeline()
+		if err != nil {
+			t.Fatalf("readPipeline() returned error %v", err)
+		}
+		if err := server.execute(client, commands); err != nil {
+			t.Fatalf("execute() returned error %v", err)
+		}
+		return buf.String()
+	}
+
+	write(`{"backend": {"addresses": ["127.0.0.1:2"]}, "filter": {"deny": ["GET"]}, "pool": {"max_active": 8}, "admin": {"enabled": true}}`)
+	if reply := run("PROXY RELOAD\r\nGET a\r\n"); reply != "+OK\r\n-ERR command 'GET' is not allowed by proxy\r\n" {
+		t.Errorf("PROXY RELOAD wrote %q", reply)
+	}
+	if server.config.Pool.MaxActive != 8 || server.router.(*singleRouter).pool == old {
+		t.Errorf("Reload() did not apply the new pool config")
+	}
+	if c := old.Get(); c.Err() == nil {
+		t.Errorf("old pool was not closed")
+	}
+
+	// 无效的配置不影响正在使用的配置
+	write(`{"backend": {"addresses": ["127.0.0.1:3"]}, "filter": {"mode": "block"}}`)
+	if reply := run("PROXY RELOAD\r\n"); !strings.HasPrefix(reply, "-ERR proxy: config: filter.mode: ") {
+		t.Errorf("PROXY RELOAD wrote %q, want the config error", reply)
+	}
+	if server.config.Backend.Addresses[0] != "127.0.0.1:2" {
+		t.Errorf("invalid config was applied")
+	}
+	if reply := run("PROXY FLUSH\r\n"); !strings.HasPrefix(reply, "-ERR unknown subcommand 'FLUSH'") {
+		t.Errorf("PROXY FLUSH wrote %q", reply)
+	}
+}
+
+func TestServer_ReloadACL(t *testing.T) {
+	server := &Server{config: &Config{Backend: BackendConfig{Addresses: []string{"127.0.0.1:1"}}}}
+	server.acl, _ = newACL(testUsers)
+	server.filter, _ = newFilter(defaultFilterConfig())
+	server.router = &singleRouter{pool: newPool("127.0.0.1:1", server.config)}
+	client := &Client{}
+	client.user = server.acl.users["app"]
+	if err := server.check(client, newCommand("DEL", "app:1")); err == nil {
+		t.Fatalf("check(DEL) returned nil, want NOPERM")
+	}
+	config := &Config{Backend: server.config.Backend, Filter: defaultFilterConfig(), Users: []UserConfig{
+		{Name: "app", Password: "pw", Commands: []string{"+@write"}},
+	}}
+	if err := server.Reload(config); err != nil {
+		t.Fatalf("Reload() returned error %v", err)
+	}
+	// 已认证的连接使用新的规则
+	if err := server.check(client, newCommand("DEL", "app:1")); err != nil {
+		t.Errorf("check(DEL) after Reload() = %v, want nil", err)
+	}
+}
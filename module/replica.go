@@ -0,0 +1,53 @@
+package module
+
+import (
+	"math/rand"
+	"sort"
+	"redisProxy/redis"
+)
+
+/*
+	replicas serving read-only commands, picked at random by weight
+ */
+type replicaSet struct {
+	pools		[]*redis.Pool
+	addresses	[]string
+	weights		[]int	// 累计权重
+}
+
+/*
+	create replica set from config, nil when no replica is configured
+ */
+func newReplicaSet(config *Config) *replicaSet {
+	if len(config.Backend.Replicas) == 0 {
+		return nil
+	}
+	s := &replicaSet{}
+	total := 0
+	for _, replica := range config.Backend.Replicas {
+		if replica.Weight == 0 {
+			total++
+		} else {
+			total += replica.Weight
+		}
+		s.pools = append(s.pools, newPool(replica.Address, config))
+		s.addresses = append(s.addresses, replica.Address)
+		s.weights = append(s.weights, total)
+	}
+	return s
+}
+
+func (s *replicaSet) pick() *redis.Pool {
+	n := rand.Intn(s.weights[len(s.weights)-1])
+	return s.pools[sort.SearchInts(s.weights, n+1)]
+}
+
+func (s *replicaSet) close() error {
+	var err error
+	for _, pool := range s.pools {
+		if e := pool.Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
@@ -0,0 +1,28 @@
+package module
+
+import (
+	"testing"
+)
+
+func TestReplicaSet_pick(t *testing.T) {
+	config := defaultConfig()
+	config.Backend.Replicas = []ServerConfig{{Address: "10.0.0.1:6379", Weight: 1}, {Address: "10.0.0.2:6379", Weight: 3}}
+	s := newReplicaSet(config)
+	defer s.close()
+
+	counts := make(map[int]int)
+	for i := 0; i < 4000; i++ {
+		pool := s.pick()
+		for j := range s.pools {
+			if s.pools[j] == pool {
+				counts[j]++
+			}
+		}
+	}
+	if counts[0] < 800 || counts[0] > 1200 || counts[0]+counts[1] != 4000 {
+		t.Errorf("picked %v, want about 1000 and 3000", counts)
+	}
+	if newReplicaSet(defaultConfig()) != nil {
+		t.Errorf("newReplicaSet() without replicas is not nil")
+	}
+}
@@ -0,0 +1,88 @@
+package module
+
+import (
+	"fmt"
+	"redisProxy/logger"
+	"redisProxy/proxy"
+	"redisProxy/redis"
+)
+
+const (
+	backendModeSingle	= "single"	// 单机redis
+	backendModeCluster	= "cluster"	// redis cluster，按slot路由
+	backendModeKetama	= "ketama"	// 多个独立redis，一致性hash分片
+	backendModeSentinel	= "sentinel"	// 通过sentinel发现master，自动切换
+)
+
+/*
+	router picks the backend pool of a command
+ */
+type router interface {
+	// pool serving the command, errors are redis error replies such as CROSSSLOT
+	route(command *proxy.Command) (*redis.Pool, error)
+
+	// shard of a key and its pool, keys of the same shard can share one command
+	shard(key []byte) (int, *redis.Pool, error)
+
+	// keys are spread over several shards
+	sharded() bool
+
+	// pool of the node a MOVED/ASK error points at, nil when the error is not a redirect
+	redirect(err redis.Error) (pool *redis.Pool, asking bool)
+
+	// backend pools by address, for stats
+	backends() map[string]*redis.Pool
+
+	// close all backend pools
+	close() error
+}
+
+/*
+	create the router of the backend mode, routers watching the backends log to log
+ */
+func newRouter(config *Config, log *logger.Logger) (router, error) {
+	switch config.Backend.Mode {
+	case "", backendModeSingle:
+		address := config.Backend.Addresses[0]
+		return &singleRouter{address: address, pool: newPool(address, config)}, nil
+	case backendModeCluster:
+		return newClusterRouter(config, log)
+	case backendModeKetama:
+		return newKetamaRouter(config), nil
+	case backendModeSentinel:
+		return newSentinelRouter(config, log)
+	}
+	return nil, &ConfigError{Key: "backend.mode", Err: fmt.Errorf("unknown backend mode %q", config.Backend.Mode)}
+}
+
+/*
+	single redis instance, every command goes to the same pool
+ */
+type singleRouter struct {
+	address	string
+	pool	*redis.Pool
+}
+
+func (r *singleRouter) route(command *proxy.Command) (*redis.Pool, error) {
+	return r.pool, nil
+}
+
+func (r *singleRouter) shard(key []byte) (int, *redis.Pool, error) {
+	return 0, r.pool, nil
+}
+
+func (r *singleRouter) sharded() bool {
+	return false
+}
+
+func (r *singleRouter) redirect(err redis.Error) (*redis.Pool, bool) {
+	return nil, false
+}
+
+func (r *singleRouter) backends() map[string]*redis.Pool {
+	return map[string]*redis.Pool{r.address: r.pool}
+}
+
+func (r *singleRouter) close() error {
+	return r.pool.Close()
+}
@@ -0,0 +1,110 @@
+package module
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"strings"
+	"sync"
+	"redisProxy/proxy"
+	"redisProxy/redis"
+)
+
+/*
+	lua scripts seen by the proxy, by sha1
+	the node an EVALSHA is routed to may not have loaded the script,
+	on NOSCRIPT the script is loaded on that node and EVALSHA retried
+ */
+
+const maxScripts = 4096	// 缓存脚本数上限，超过后不再缓存新脚本
+
+type scriptCache struct {
+	mu	sync.RWMutex
+	scripts	map[string][]byte
+}
+
+/*
+	cache the scripts of EVAL and SCRIPT LOAD, SCRIPT FLUSH empties the cache
+ */
+func (sc *scriptCache) learn(command *proxy.Command) {
+	var script []byte
+	switch command.CommandName() {
+	case "EVAL":
+		if len(command.Args) == 0 {
+			return
+		}
+		script = command.Args[0]
+	case "SCRIPT":
+		if len(command.Args) == 0 {
+			return
+		}
+		switch strings.ToUpper(string(command.Args[0])) {
+		case "LOAD":
+			if len(command.Args) < 2 {
+				return
+			}
+			script = command.Args[1]
+		case "FLUSH":
+			sc.mu.Lock()
+			sc.scripts = nil
+			sc.mu.Unlock()
+			return
+		default:
+			return
+		}
+	default:
+		return
+	}
+	sum := sha1.Sum(script)
+	sha := hex.EncodeToString(sum[:])
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	if sc.scripts == nil {
+		sc.scripts = make(map[string][]byte)
+	}
+	if _, ok := sc.scripts[sha]; !ok && len(sc.scripts) < maxScripts {
+		sc.scripts[sha] = script
+	}
+}
+
+func (sc *scriptCache) script(sha string) ([]byte, bool) {
+	sc.mu.RLock()
+	defer sc.mu.RUnlock()
+	script, ok := sc.scripts[strings.ToLower(sha)]
+	return script, ok
+}
+
+func isNoScript(command *proxy.Command, err redis.Error) bool {
+	return strings.HasPrefix(string(err), "NOSCRIPT") && command.CommandName() == "EVALSHA" && len(command.Args) > 0
+}
+
+/*
+	load a cached script on the pool of a NOSCRIPT reply and retry EVALSHA,
+	err is returned when the script is unknown to the proxy
+ */
+func (sc *scriptCache) reload(pool *redis.Pool, command *proxy.Command, err redis.Error) interface{} {
+	script, ok := sc.script(string(command.Args[0]))
+	if !ok {
+		return err
+	}
+	c := pool.Get()
+	defer c.Close()
+	c.Send("SCRIPT", "LOAD", script)
+	c.Send(string(command.Name), commandArgs(command)...)
+	if err := c.Flush(); err != nil {
+		return redis.Error("ERR proxy: " + err.Error())
+	}
+	if _, err := c.Receive(); err != nil {
+		if e, ok := err.(redis.Error); ok {
+			return e
+		}
+		return redis.Error("ERR proxy: " + err.Error())
+	}
+	reply, e := c.Receive()
+	if e != nil {
+		if re, ok := e.(redis.Error); ok {
+			return re
+		}
+		return redis.Error("ERR proxy: " + e.Error())
+	}
+	return reply
+}
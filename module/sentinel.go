@@ -0,0 +1,216 @@
+package module
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+	"redisProxy/logger"
+	"redisProxy/proxy"
+	"redisProxy/redis"
+)
+
+/*
+	redis sentinel discovery
+	the master is resolved with SENTINEL get-master-addr-by-name,
+	+switch-master events swap the backend pool, the old pool drains as connections return
+ */
+
+const sentinelRetryInterval = time.Second	// sentinel不可用时的重试间隔
+
+type sentinelRouter struct {
+	config		*Config
+	name		string
+	sentinels	[]string
+	log		*logger.Logger
+
+	mu	sync.RWMutex
+	master	string
+	pool	*redis.Pool
+	sub	redis.Conn	// 订阅+switch-master的连接
+	closed	bool
+}
+
+/*
+	create sentinel router, the master must be resolvable at startup
+ */
+func newSentinelRouter(config *Config, log *logger.Logger) (*sentinelRouter, error) {
+	r := &sentinelRouter{
+		config: config,
+		log: log,
+		name: config.Backend.MasterName,
+		sentinels: config.Backend.Addresses,
+	}
+	master, err := r.resolve()
+	if err != nil {
+		return nil, err
+	}
+	r.master = master
+	r.pool = newPool(master, config)
+	go r.watch()
+	return r, nil
+}
+
+/*
+	address of the master from the first sentinel that knows it
+ */
+func (r *sentinelRouter) resolve() (string, error) {
+	var err error
+	for _, sentinel := range r.sentinels {
+		var master string
+		if master, err = r.masterAddr(sentinel); err == nil {
+			return master, nil
+		}
+	}
+	return "", fmt.Errorf("sentinel: cannot resolve master %q: %v", r.name, err)
+}
+
+func (r *sentinelRouter) masterAddr(sentinel string) (string, error) {
+	c, err := redis.Dial("tcp", sentinel, r.config.Backend.sentinelDialOptions()...)
+	if err != nil {
+		return "", err
+	}
+	defer c.Close()
+	reply, err := redis.Strings(c.Do("SENTINEL", "get-master-addr-by-name", r.name))
+	if err == redis.ErrNil {
+		return "", fmt.Errorf("sentinel %s does not monitor %q", sentinel, r.name)
+	}
+	if err != nil {
+		return "", err
+	}
+	if len(reply) != 2 {
+		return "", fmt.Errorf("sentinel %s: bad master address %q", sentinel, reply)
+	}
+	return net.JoinHostPort(reply[0], reply[1]), nil
+}
+
+/*
+	follow +switch-master events, sentinels are tried in turn,
+	the master is resolved again after reconnecting in case an event was missed
+ */
+func (r *sentinelRouter) watch() {
+	for i := 0; ; i++ {
+		sentinel := r.sentinels[i%len(r.sentinels)]
+		err := r.subscribe(sentinel)
+		if r.isClosed() {
+			return
+		}
+		r.log.Warn("sentinel connection failed, retrying", "sentinel", sentinel, "err", err)
+		time.Sleep(sentinelRetryInterval)
+		if master, err := r.resolve(); err == nil {
+			r.switchMaster(master)
+		}
+	}
+}
+
+func (r *sentinelRouter) subscribe(sentinel string) error {
+	c, err := redis.Dial("tcp", sentinel, r.config.Backend.sentinelDialOptions()...)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	r.mu.Lock()
+	if r.closed {
+		r.mu.Unlock()
+		return nil
+	}
+	r.sub = c
+	r.mu.Unlock()
+	c.Send("SUBSCRIBE", "+switch-master")
+	if err := c.Flush(); err != nil {
+		return err
+	}
+	for {
+		reply, err := redis.Values(c.Receive())
+		if err != nil {
+			return err
+		}
+		// message +switch-master "<name> <old ip> <old port> <new ip> <new port>"
+		if len(reply) != 3 {
+			continue
+		}
+		kind, _ := redis.String(reply[0], nil)
+		payload, _ := redis.String(reply[2], nil)
+		if kind != "message" {
+			continue
+		}
+		if master, ok := parseSwitchMaster(payload, r.name); ok {
+			r.switchMaster(master)
+		}
+	}
+}
+
+/*
+	new master address of a +switch-master payload for the named master
+ */
+func parseSwitchMaster(payload, name string) (string, bool) {
+	fields := strings.Fields(payload)
+	if len(fields) != 5 || fields[0] != name {
+		return "", false
+	}
+	return net.JoinHostPort(fields[3], fields[4]), true
+}
+
+/*
+	swap the backend pool atomically, connections of the old master
+	are closed when they return to the closed pool
+ */
+func (r *sentinelRouter) switchMaster(master string) {
+	r.mu.Lock()
+	if r.closed || master == r.master {
+		r.mu.Unlock()
+		return
+	}
+	old, oldMaster := r.pool, r.master
+	r.master = master
+	r.pool = newPool(master, r.config)
+	r.mu.Unlock()
+	r.log.Info("sentinel master switched", "master_name", r.name, "from", oldMaster, "to", master)
+	old.Close()
+}
+
+func (r *sentinelRouter) isClosed() bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.closed
+}
+
+func (r *sentinelRouter) current() *redis.Pool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.pool
+}
+
+func (r *sentinelRouter) route(command *proxy.Command) (*redis.Pool, error) {
+	return r.current(), nil
+}
+
+func (r *sentinelRouter) shard(key []byte) (int, *redis.Pool, error) {
+	return 0, r.current(), nil
+}
+
+func (r *sentinelRouter) sharded() bool {
+	return false
+}
+
+func (r *sentinelRouter) redirect(err redis.Error) (*redis.Pool, bool) {
+	return nil, false
+}
+
+func (r *sentinelRouter) backends() map[string]*redis.Pool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return map[string]*redis.Pool{r.master: r.pool}
+}
+
+func (r *sentinelRouter) close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.closed = true
+	if r.sub != nil {
+		// 中断订阅连接上阻塞的Receive
+		r.sub.Close()
+	}
+	return r.pool.Close()
+}
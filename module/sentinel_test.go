@@ -0,0 +1,44 @@
+package module
+
+import (
+	"testing"
+	"redisProxy/redis"
+)
+
+func TestParseSwitchMaster(t *testing.T) {
+	for _, tt := range []struct {
+		payload string
+		master  string
+		ok      bool
+	}{
+		{"mymaster 10.0.0.1 6379 10.0.0.2 6380", "10.0.0.2:6380", true},
+		{"other 10.0.0.1 6379 10.0.0.2 6380", "", false},
+		{"mymaster 10.0.0.1 6379", "", false},
+	} {
+		master, ok := parseSwitchMaster(tt.payload, "mymaster")
+		if master != tt.master || ok != tt.ok {
+			t.Errorf("parseSwitchMaster(%q) = %q, %v, want %q, %v", tt.payload, master, ok, tt.master, tt.ok)
+		}
+	}
+}
+
+func TestSentinelRouter_switchMaster(t *testing.T) {
+	old := &redis.Pool{Dial: func() (redis.Conn, error) { return &echoConn{}, nil }, MaxIdle: 1}
+	r := &sentinelRouter{config: defaultConfig(), name: "mymaster", master: "10.0.0.1:6379", pool: old}
+	defer r.close()
+
+	// 切换前借出的连接在归还时关闭
+	c := old.Get()
+	r.switchMaster("10.0.0.1:6379")
+	if pool, _ := r.route(newCommand("GET", "a")); pool != old {
+		t.Fatalf("switchMaster() to the same master replaced the pool")
+	}
+	r.switchMaster("10.0.0.2:6379")
+	if pool, _ := r.route(newCommand("GET", "a")); pool == old || r.master != "10.0.0.2:6379" {
+		t.Fatalf("switchMaster() kept the old pool")
+	}
+	c.Close()
+	if old.ActiveCount() != 0 {
+		t.Errorf("old pool active = %d, want 0", old.ActiveCount())
+	}
+}
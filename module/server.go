@@ -2,10 +2,21 @@ package module
 
 import (
 	"net"
-	"log"
 	"bufio"
-	"github.com/garyburd/redigo/redis"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"redisProxy/internal"
+	"redisProxy/logger"
+	"redisProxy/proxy"
+	"redisProxy/redis"
 )
 
 /*
@@ -13,71 +24,646 @@ import (
  */
 
 type Server struct {
-	clients		[]*Client
 	address		string
+
+	// guards config, filter, acl, router and replicas, see Reload
+	configMu	sync.RWMutex
+	// serializes reloads and PROXY CONFIG SET
+	reloadMu	sync.Mutex
+	config		*Config
+	filter		*filter
+	acl		*acl
+	router		router
+	replicas	*replicaSet
+	pubsub		*pubsub
+	scripts		scriptCache
+	metrics		metrics
+	slowlog		slowlog
+	log		*logger.Logger
+
+	// dedicated connections of blocking commands per pool, see Pool.MaxBlocking
+	blockingMu	sync.Mutex
+	blocking	map[*redis.Pool]int
+
+	// clients and their activity, see Shutdown
+	mu		sync.Mutex
+	clients		map[*Client]bool
+	listener	net.Listener
+	metricsServer	*http.Server
+	closing		bool
+	closeOnce	sync.Once
+
+	started		time.Time
+	lastClientID	int64
+}
+
+/*
+	create server from config, logging to stderr as Config.Log says
+ */
+func NewServer(config *Config) (*Server, error) {
+	return NewServerWithLogger(config, config.Log.Logger(os.Stderr))
+}
+
+/*
+	create server logging to log, a nil log discards the log
+ */
+func NewServerWithLogger(config *Config, log *logger.Logger) (*Server, error) {
+	f, err := newFilter(config.Filter)
+	if err != nil {
+		return nil, err
+	}
+	a, err := newACL(config.Users)
+	if err != nil {
+		return nil, err
+	}
+	r, err := newRouter(config, log)
+	if err != nil {
+		return nil, err
+	}
+	server := &Server{
+		address: config.Listen,
+		config: config,
+		filter: f,
+		acl: a,
+		router: r,
+		replicas: newReplicaSet(config),
+		started: time.Now(),
+		log: log,
+	}
+	server.pubsub = newPubsub(server)
+	return server, nil
 }
 
 /*
 	handlerConnection(conn)
+	the client is idle while it waits for commands and active while it runs them
  */
 func (server *Server) handleConnection(conn net.Conn){
-	var filter filter	//引入命令过滤器
-	var utils utils		//引入工具类
+	server.configMu.RLock()
+	buffer := server.config.Buffer
+	server.configMu.RUnlock()
 	client := &Client{
 		conn:conn,
 		server:server,
-		reader:bufio.NewReader(conn),
-		writer:bufio.NewWriter(conn),
-		bufferSize:1024,
+		reader:bufio.NewReaderSize(conn, buffer.Read),
+		writer:proxy.NewWriter(conn, buffer.Write),
+		bufferSize:buffer.Read,
+		id:atomic.AddInt64(&server.lastClientID, 1),
+		created:time.Now(),
+	}
+	client.log = server.log.With("client_id", client.id, "remote_addr", conn.RemoteAddr().String())
+	defer client.Close()
+	if !server.addClient(client) {
+		return
 	}
-	c, _ := redis.Dial("tcp","xxxx",redis.DialPassword("xxxx"))
+	defer server.removeClient(client)
+	server.metrics.accept()
+	client.log.Debug("client connected")
 	for {
-		reply, _ := client.Receive()
-		if reply != nil{
-			message := utils.convertInterfaceToSlice(reply)
-			command, _ := utils.convertInterfaceToString(message[0])
-			f := filter.filter()
-			_, ok := f[strings.ToUpper(command)]
-			if !ok{
-				actual, err := c.Do(command, message[1:]...)
-				if actual != nil && err == nil{
-					result, err := redis.Bytes(actual, nil)
-					if err != nil{
-						log.Fatal(err)
+		if !server.setActive(client, false) {
+			return
+		}
+		commands, err := client.readPipeline()
+		if err != nil {
+			// 断开连接不算协议错误
+			var ne net.Error
+			if err == io.EOF || err == io.ErrUnexpectedEOF || errors.As(err, &ne) {
+				client.log.Debug("client disconnected", "err", err)
+			} else {
+				server.metrics.protocolError()
+				client.log.Warn("protocol error, closing the client connection", "err", err)
+			}
+			return
+		}
+		server.setActive(client, true)
+		if err := server.execute(client, commands); err != nil {
+			var be *backendError
+			if errors.As(err, &be) {
+				client.log.Warn("backend connection failed, closing the client connection", "backend", be.address, "err", be.err)
+			} else {
+				client.log.Debug("client disconnected", "err", err)
+			}
+			return
+		}
+	}
+}
+
+/*
+	run a batch of commands, pub/sub, blocking and admin commands split the batch into pipelines
+ */
+func (server *Server) execute(client *Client, commands []*proxy.Command) error {
+	start := 0
+	for i, command := range commands {
+		var run func(*Client, *proxy.Command) error
+		if server.pubsub.handles(client, command) {
+			run = server.pubsub.execute
+		} else if server.blocks(client, command) {
+			run = server.block
+		} else if command.CommandName() == "PROXY" {
+			run = server.admin
+		} else {
+			continue
+		}
+		if err := server.lockedPipeline(client, commands[start:i]); err != nil {
+			return err
+		}
+		begin := time.Now()
+		if err := run(client, command); err != nil {
+			return err
+		}
+		server.metrics.observe(commands[i:i+1], time.Since(begin))
+		start = i + 1
+	}
+	return server.lockedPipeline(client, commands[start:])
+}
+
+func (server *Server) lockedPipeline(client *Client, commands []*proxy.Command) error {
+	if len(commands) == 0 {
+		return nil
+	}
+	begin := time.Now()
+	trace := newBatchTrace(begin, len(commands))
+	server.configMu.RLock()
+	client.mu.Lock()
+	replies, err := server.roundTrip(client, commands, trace)
+	// 写回客户端时不持有配置锁，慢客户端不会阻塞Reload
+	server.configMu.RUnlock()
+	err = writeReplies(client, replies, err)
+	client.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	written := time.Now()
+	server.metrics.observe(commands, written.Sub(begin))
+	server.logSlow(client, commands, trace, written)
+	return nil
+}
+
+/*
+	backend connection of a command
+	stateful sessions (MULTI, WATCH, SUBSCRIBE, SELECT...) keep a pinned connection,
+	stateless commands share one connection per backend pool within a batch,
+	the pool is nil for the pinned connection
+ */
+func (server *Server) conn(client *Client, command *proxy.Command, batch map[*redis.Pool]redis.Conn) (redis.Conn, *redis.Pool, error) {
+	if client.pinned != nil {
+		return client.pinned, nil, nil
+	}
+	if server.readFromReplica(client, command) {
+		pool := server.replicas.pick()
+		if c, err := server.batchConn(pool, batch); err == nil {
+			return c, pool, nil
+		}
+		// 从库不可用时读主库
+	}
+	pool, err := server.router.route(command)
+	if err != nil {
+		return nil, nil, err
+	}
+	c, err := server.batchConn(pool, batch)
+	return c, pool, err
+}
+
+/*
+	read-only commands go to a replica unless the client wrote recently
+ */
+func (server *Server) readFromReplica(client *Client, command *proxy.Command) bool {
+	if server.replicas == nil {
+		return false
+	}
+	ci := internal.LookupCommandInfo(command.CommandName())
+	if ci.Flags&internal.ReadFlag == 0 {
+		return false
+	}
+	return time.Since(client.lastWrite) >= server.config.Backend.ReadYourWrites.value()
+}
+
+/*
+	connection of a pool shared by the commands of a batch
+ */
+func (server *Server) batchConn(pool *redis.Pool, batch map[*redis.Pool]redis.Conn) (redis.Conn, error) {
+	if c, ok := batch[pool]; ok {
+		return c, nil
+	}
+	c := pool.Get()
+	if err := c.Err(); err != nil {
+		// 连接池耗尽或拨号失败
+		c.Close()
+		return nil, redis.Error("ERR proxy: " + err.Error())
+	}
+	batch[pool] = c
+	return c, nil
+}
+
+/*
+	addresses of the backend pools, called with configMu held
+ */
+func (server *Server) nodes() map[*redis.Pool]string {
+	nodes := make(map[*redis.Pool]string)
+	for address, pool := range server.router.backends() {
+		nodes[pool] = address
+	}
+	if server.replicas != nil {
+		for i, pool := range server.replicas.pools {
+			nodes[pool] = server.replicas.addresses[i]
+		}
+	}
+	return nodes
+}
+
+/*
+	address of a backend pool, empty when a reload replaced the pool
+ */
+func (server *Server) node(pool *redis.Pool) string {
+	server.configMu.RLock()
+	defer server.configMu.RUnlock()
+	return server.nodes()[pool]
+}
+
+/*
+	error of the backend connection of a part, called with configMu held
+ */
+func (server *Server) backendError(client *Client, p *part, err error) error {
+	return &backendError{address: server.nodes()[client.partPool(p)], err: err}
+}
+
+/*
+	pin the connection once the client session has state, unpin it when the state is cleared
+	unpinned connections are closed after their replies are read
+ */
+func (server *Server) track(client *Client, command *proxy.Command, p *part, batch map[*redis.Pool]redis.Conn, done []redis.Conn) []redis.Conn {
+	c := p.conn
+	if client.state != 0 && client.pinned == nil {
+		if server.router.sharded() && !client.shardBound {
+			client.shard, client.shardBound, _ = server.commandShard(command)
+		}
+		client.pinned, client.pinnedPool = c, p.pool
+		for pool, bc := range batch {
+			if bc == c {
+				delete(batch, pool)
+			}
+		}
+	} else if client.state == 0 && client.pinned == c {
+		client.pinned = nil
+		client.shardBound = false
+		client.aborted = false
+		done = append(done, c)
+	}
+	return done
+}
+
+/*
+	parts of a command: one part per shard for split multi-key commands,
+	otherwise the command itself on the connection it is routed to
+ */
+func (server *Server) parts(client *Client, command *proxy.Command, batch map[*redis.Pool]redis.Conn) ([]*part, error) {
+	if client.pinned == nil {
+		parts, err := server.split(command)
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range parts {
+			if p.conn, err = server.batchConn(p.pool, batch); err != nil {
+				return nil, err
+			}
+		}
+		if parts != nil {
+			return parts, nil
+		}
+	}
+	c, pool, err := server.conn(client, command, batch)
+	if err != nil {
+		return nil, err
+	}
+	ci := internal.LookupCommandInfo(command.CommandName())
+	return []*part{{conn: c, pool: pool, command: command, redirectable: client.pinned == nil && ci.Set == 0}}, nil
+}
+
+/*
+	commands changing the user, the protocol or the authentication of the backend connection,
+	which every later client of the shared connection would inherit, the proxy rejects them
+	AUTH is answered by the acl when users are configured
+ */
+var sessionCommands = map[string]redis.Error{
+	"AUTH":  redis.Error("ERR AUTH <password> called without any password configured for the default user. Are you sure your configuration is correct?"),
+	"HELLO": redis.Error("NOPROTO unsupported protocol version, the proxy only speaks RESP2"),
+	"RESET": redis.Error("ERR proxy: RESET is not supported"),
+}
+
+/*
+	CLIENT SETNAME and GETNAME are answered by the proxy,
+	the name would otherwise stay on a backend connection shared with other clients
+ */
+func (server *Server) clientName(client *Client, command *proxy.Command) (interface{}, bool) {
+	if command.CommandName() != "CLIENT" || len(command.Args) == 0 {
+		return nil, false
+	}
+	switch sub := strings.ToUpper(string(command.Args[0])); sub {
+	case "SETNAME", "GETNAME":
+		if client.state&internal.MultiState != 0 {
+			return server.abort(client, redis.Error(fmt.Sprintf("ERR proxy: CLIENT %s is not supported inside MULTI", sub))), true
+		}
+		if sub == "GETNAME" {
+			if len(command.Args) != 1 {
+				return redis.Error("ERR wrong number of arguments for 'client|getname' command"), true
+			}
+			if client.name == "" {
+				return nil, true
+			}
+			return []byte(client.name), true
+		}
+		if len(command.Args) != 2 {
+			return redis.Error("ERR wrong number of arguments for 'client|setname' command"), true
+		}
+		for _, c := range command.Args[1] {
+			// 与redis相同，名字不能包含空格和特殊字符
+			if c <= ' ' || c > '~' {
+				return redis.Error("ERR Client names cannot contain spaces, newlines or special characters."), true
+			}
+		}
+		client.name = string(command.Args[1])
+		return "OK", true
+	}
+	return nil, false
+}
+
+/*
+	parts of a command to send, or the reply of a command the proxy answers itself
+ */
+func (server *Server) prepare(client *Client, command *proxy.Command, batch map[*redis.Pool]redis.Conn) ([]*part, interface{}) {
+	// 代理鉴权，与后端密码无关
+	if err := server.acl.check(client, command); err != nil {
+		return nil, server.abort(client, err)
+	}
+	if server.acl != nil && command.CommandName() == "AUTH" {
+		return nil, server.acl.auth(client, command)
+	}
+	// 过滤redisProxy不支持的命令
+	if err := server.filterCheck(command); err != nil {
+		return nil, server.abort(client, err)
+	}
+	if reply, ok := server.clientName(client, command); ok {
+		return nil, reply
+	}
+	if err, ok := sessionCommands[command.CommandName()]; ok {
+		return nil, server.abort(client, err)
+	}
+	if parts, reply, ok := server.transaction(client, command, batch); ok {
+		return parts, reply
+	}
+	parts, err := server.parts(client, command, batch)
+	if err != nil {
+		// CROSSSLOT等路由错误作为回复返回
+		return nil, server.abort(client, err)
+	}
+	return parts, nil
+}
+
+/*
+	ACL and filter checks of the commands run outside the pipeline
+ */
+func (server *Server) check(client *Client, command *proxy.Command) error {
+	server.configMu.RLock()
+	defer server.configMu.RUnlock()
+	if err := server.acl.check(client, command); err != nil {
+		return err
+	}
+	return server.filterCheck(command)
+}
+
+/*
+	forward pipelined commands and write their replies
+ */
+func (server *Server) pipeline(client *Client, commands []*proxy.Command) error {
+	replies, err := server.roundTrip(client, commands, nil)
+	return writeReplies(client, replies, err)
+}
+
+/*
+	write the replies of a pipeline, the replies received before a connection error
+	are written before the error is returned
+ */
+func writeReplies(client *Client, replies []interface{}, err error) error {
+	for _, reply := range replies {
+		if err := client.writer.WriteReply(reply); err != nil {
+			return err
+		}
+	}
+	if err != nil {
+		return err
+	}
+	return client.writer.Flush()
+}
+
+/*
+	forward pipelined commands to the backends with a single Flush per connection,
+	then collect the replies in request order, timestamps go to trace unless it is nil
+ */
+func (server *Server) roundTrip(client *Client, commands []*proxy.Command, trace *batchTrace) ([]interface{}, error) {
+	replies := make([]interface{}, len(commands))
+	sent := make([][]*part, len(commands))
+	batch := make(map[*redis.Pool]redis.Conn)
+	var done []redis.Conn
+	defer func() {
+		for _, c := range batch {
+			c.Close()
+		}
+		for _, c := range done {
+			c.Close()
+		}
+		if client.pinned != nil && client.pinned.Err() != nil {
+			client.pinned.Close()
+			client.pinned = nil
+			client.state = 0
+			client.shardBound = false
+			client.aborted = false
+		}
+	}()
+	for i, command := range commands {
+		parts, reply := server.prepare(client, command, batch)
+		if parts == nil {
+			replies[i] = reply
+			continue
+		}
+		for _, p := range parts {
+			if p.multi {
+				p.conn.Send("MULTI")
+			}
+			if err := p.conn.Send(string(p.command.Name), commandArgs(p.command)...); err != nil {
+				return nil, server.backendError(client, p, err)
+			}
+		}
+		sent[i] = parts
+		server.scripts.learn(command)
+		ci := internal.LookupCommandInfo(command.CommandName())
+		client.state = (client.state | ci.Set) &^ ci.Clear
+		if ci.Flags&internal.WriteFlag != 0 {
+			client.lastWrite = time.Now()
+		}
+		done = server.track(client, command, parts[0], batch, done)
+	}
+	flushed := make(map[redis.Conn]bool)
+	for _, parts := range sent {
+		for _, p := range parts {
+			if flushed[p.conn] {
+				continue
+			}
+			flushed[p.conn] = true
+			if err := p.conn.Flush(); err != nil {
+				return nil, server.backendError(client, p, err)
+			}
+		}
+	}
+	if trace != nil && len(flushed) != 0 {
+		trace.flushed = time.Now()
+	}
+	for i := range commands {
+		if parts := sent[i]; parts != nil {
+			partReplies := make([]interface{}, len(parts))
+			for j, p := range parts {
+				if p.multi {
+					if _, err := p.conn.Receive(); err != nil {
+						if _, ok := err.(redis.Error); !ok {
+							return replies[:i], server.backendError(client, p, err)
+						}
 					}
-					res, _ := utils.parseMessage(result)
-					client.SendBytes(res)
-				}else {
-					// 执行Do之后出现异常， 返回空消息
-					result :=  []byte("")
-					res, _ :=  utils.parseMessage(result)
-					client.SendBytes(res)
 				}
-			}else {
-				// 过滤redisProxy不支持的命令
-				result := []byte("ERR command not support")
-				res, _ := utils.parseMessage(result)
-				client.SendBytes(res)
+				reply, err := p.conn.Receive()
+				if e, ok := err.(redis.Error); ok && p.redirectable {
+					reply = server.redirect(p.command, e)
+				} else if ok {
+					// 错误回复原样返回给客户端
+					reply = e
+				} else if err != nil {
+					return replies[:i], server.backendError(client, p, err)
+				}
+				if e, ok := reply.(redis.Error); ok && p.pool != nil && isNoScript(p.command, e) {
+					reply = server.scripts.reload(p.pool, p.command, e)
+				}
+				if p.reply != nil {
+					reply = p.reply
+				}
+				partReplies[j] = reply
+			}
+			if len(parts) == 1 {
+				replies[i] = partReplies[0]
+			} else {
+				replies[i] = merge(commands[i], parts, partReplies)
+			}
+			if trace != nil {
+				trace.received[i] = time.Now()
+				trace.pools[i] = partPools(client, parts)
 			}
 		}
 	}
+	return replies, nil
 }
 
 /*
-	listen tcp server
+	follow cluster MOVED/ASK redirects, at most Backend.MaxRedirects hops,
+	the reply of the last node is returned, other errors are returned as is
  */
-func (server *Server) Listen() {
+func (server *Server) redirect(command *proxy.Command, e redis.Error) interface{} {
+	for hops := 0; ; hops++ {
+		pool, asking := server.router.redirect(e)
+		if pool == nil || hops >= server.config.Backend.MaxRedirects {
+			return e
+		}
+		reply, err := forward(pool, command, asking)
+		if re, ok := err.(redis.Error); ok {
+			e = re
+			continue
+		}
+		if err != nil {
+			return redis.Error("ERR proxy: " + err.Error())
+		}
+		return reply
+	}
+}
+
+/*
+	send a single command to a pool, prefixed by ASKING for ASK redirects
+ */
+func forward(pool *redis.Pool, command *proxy.Command, asking bool) (interface{}, error) {
+	c := pool.Get()
+	defer c.Close()
+	if asking {
+		c.Send("ASKING")
+	}
+	c.Send(string(command.Name), commandArgs(command)...)
+	if err := c.Flush(); err != nil {
+		return nil, err
+	}
+	if asking {
+		if _, err := c.Receive(); err != nil {
+			return nil, err
+		}
+	}
+	return c.Receive()
+}
+
+func commandArgs(command *proxy.Command) []interface{} {
+	args := make([]interface{}, len(command.Args))
+	for i, arg := range command.Args {
+		args[i] = arg
+	}
+	return args
+}
+
+/*
+	listen tcp server, TLS when the listener has a certificate
+	returns ErrServerClosed after Shutdown
+ */
+func (server *Server) Listen() error {
 
 	listener, err := net.Listen("tcp", server.address)
 	if err != nil{
-		log.Fatal("Error starting TCP server")
+		return err
+	}
+	server.configMu.RLock()
+	tlsConfig, metricsAddress := server.config.TLS.config, server.config.Metrics.Listen
+	server.configMu.RUnlock()
+	if tlsConfig != nil {
+		// 客户端连接的TLS握手在第一次读时完成
+		listener = tls.NewListener(listener, tlsConfig)
 	}
+	server.mu.Lock()
+	if server.closing {
+		server.mu.Unlock()
+		listener.Close()
+		return ErrServerClosed
+	}
+	server.listener = listener
+	server.mu.Unlock()
+	if metricsAddress != "" {
+		go server.serveMetrics(metricsAddress)
+	}
+	server.log.Info("listening", "listen", server.address, "tls", tlsConfig != nil)
 	defer listener.Close()
+	var delay time.Duration
 	for {
 		conn, err := listener.Accept()
 		if err != nil {
-			log.Fatal(err)
+			server.mu.Lock()
+			closing := server.closing
+			server.mu.Unlock()
+			if closing {
+				return ErrServerClosed
+			}
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				// 文件描述符耗尽等临时错误，退避后重试
+				if delay == 0 {
+					delay = 5 * time.Millisecond
+				} else if delay *= 2; delay > time.Second {
+					delay = time.Second
+				}
+				server.log.Warn("accept error, retrying", "err", err, "delay", delay)
+				time.Sleep(delay)
+				continue
+			}
+			return err
 		}
+		delay = 0
 		go server.handleConnection(conn)	// 调用处理方法
 	}
 }
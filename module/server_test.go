@@ -0,0 +1,508 @@
+package module
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"redisProxy/logger"
+	"redisProxy/proxy"
+	"redisProxy/redis"
+)
+
+/*
+	fake backend connection with a canned reply per command
+ */
+type echoConn struct {
+	sent    []string
+	pending []interface{}
+	flushed int
+	errors  map[string]redis.Error
+}
+
+func (c *echoConn) Close() error { return nil }
+func (c *echoConn) Err() error   { return nil }
+func (c *echoConn) Flush() error { c.flushed++; return nil }
+
+func (c *echoConn) Do(commandName string, args ...interface{}) (interface{}, error) {
+	if commandName == "" {
+		return nil, nil
+	}
+	c.Send(commandName, args...)
+	return c.Receive()
+}
+
+func (c *echoConn) Send(commandName string, args ...interface{}) error {
+	c.sent = append(c.sent, commandName)
+	if e, ok := c.errors[commandName]; ok {
+		c.pending = append(c.pending, e)
+		return nil
+	}
+	switch commandName {
+	case "PING":
+		c.pending = append(c.pending, "PONG")
+	case "MULTI", "SELECT":
+		c.pending = append(c.pending, "OK")
+	case "EXEC":
+		c.pending = append(c.pending, []interface{}{int64(1)})
+	case "INCR":
+		c.pending = append(c.pending, int64(1))
+	case "LPOP":
+		c.pending = append(c.pending, nil)
+	case "MSET":
+		c.pending = append(c.pending, "OK")
+	case "DEL", "EXISTS":
+		c.pending = append(c.pending, int64(len(args)))
+	case "MGET":
+		values := make([]interface{}, len(args))
+		for i, arg := range args {
+			values[i] = arg
+		}
+		c.pending = append(c.pending, values)
+	case "HGETALL":
+		c.pending = append(c.pending, []interface{}{[]byte("f"), []byte("v")})
+	case "BAD":
+		c.pending = append(c.pending, redis.Error("ERR unknown command 'BAD'"))
+	default:
+		c.pending = append(c.pending, []byte(commandName))
+	}
+	return nil
+}
+
+func (c *echoConn) Receive() (interface{}, error) {
+	reply := c.pending[0]
+	c.pending = c.pending[1:]
+	if err, ok := reply.(redis.Error); ok {
+		return nil, err
+	}
+	return reply, nil
+}
+
+func TestServer_pipeline(t *testing.T) {
+	var buf bytes.Buffer
+	client := &Client{
+		reader: bufio.NewReader(strings.NewReader("*2\r\n$3\r\nGET\r\n$1\r\na\r\nKEYS *\r\nPING\r\nINCR a\r\nLPOP l\r\nHGETALL h\r\nBAD\r\n")),
+		writer: proxy.NewWriter(&buf, 4096),
+	}
+	f, _ := newFilter(defaultFilterConfig())
+	c := &echoConn{}
+	server := &Server{
+		filter: f,
+		router: &singleRouter{pool: &redis.Pool{Dial: func() (redis.Conn, error) { return c, nil }}},
+	}
+
+	commands, err := client.readPipeline()
+	if err != nil {
+		t.Fatalf("readPipeline() returned error %v", err)
+	}
+	if len(commands) != 7 {
+		t.Fatalf("readPipeline() returned %d commands, want 7", len(commands))
+	}
+	if err := server.pipeline(client, commands); err != nil {
+		t.Fatalf("pipeline() returned error %v", err)
+	}
+	if c.flushed != 1 || strings.Join(c.sent, " ") != "GET PING INCR LPOP HGETALL BAD" {
+		t.Errorf("sent %v with %d flushes, want [GET PING INCR LPOP HGETALL BAD] with 1 flush", c.sent, c.flushed)
+	}
+	expected := "$3\r\nGET\r\n" +
+		"-ERR command 'KEYS' is not allowed by proxy\r\n" +
+		"+PONG\r\n" +
+		":1\r\n" +
+		"$-1\r\n" +
+		"*2\r\n$1\r\nf\r\n$1\r\nv\r\n" +
+		"-ERR unknown command 'BAD'\r\n"
+	if buf.String() != expected {
+		t.Errorf("pipeline() wrote %q, want %q", buf.String(), expected)
+	}
+}
+
+func TestServer_pipelinePinning(t *testing.T) {
+	var buf bytes.Buffer
+	client := &Client{
+		reader: bufio.NewReader(strings.NewReader("MULTI\r\nINCR a\r\nEXEC\r\nSELECT 1\r\n")),
+		writer: proxy.NewWriter(&buf, 4096),
+	}
+	f, _ := newFilter(FilterConfig{Mode: "allow", Allow: []string{"MULTI", "INCR", "EXEC", "SELECT"}})
+	dialed := 0
+	pool := &redis.Pool{
+		Dial: func() (redis.Conn, error) { dialed++; return &echoConn{}, nil },
+		MaxIdle: 1,
+	}
+	server := &Server{
+		filter: f,
+		router: &singleRouter{pool: pool},
+	}
+	pinned := []bool{true, true, false, true}
+	for i, expected := range pinned {
+		command, err := client.readCommand()
+		if err != nil {
+			t.Fatalf("readCommand() returned error %v", err)
+		}
+		if err := server.pipeline(client, []*proxy.Command{command}); err != nil {
+			t.Fatalf("pipeline(%s) returned error %v", command.Name, err)
+		}
+		if (client.pinned != nil) != expected {
+			t.Errorf("after %s: pinned = %v, want %v", command.Name, client.pinned != nil, expected)
+		}
+		if i == 1 && pool.ActiveCount() != 1 {
+			t.Errorf("after %s: active = %d, want 1", command.Name, pool.ActiveCount())
+		}
+	}
+	if dialed != 1 {
+		t.Errorf("dialed = %d, want 1", dialed)
+	}
+	// SELECT changed the database, the connection must not go back to the pool
+	client.conn, _ = net.Pipe()
+	client.Close()
+	if pool.ActiveCount() != 0 {
+		t.Errorf("after Close: active = %d, want 0", pool.ActiveCount())
+	}
+}
+
+func TestServer_pipelineClientName(t *testing.T) {
+	f, _ := newFilter(FilterConfig{Mode: "allow", Allow: []string{"CLIENT SETNAME", "CLIENT GETNAME", "GET"}})
+	c := &echoConn{}
+	server := &Server{
+		filter: f,
+		router: &singleRouter{pool: &redis.Pool{Dial: func() (redis.Conn, error) { return c, nil }}},
+	}
+	run := func(client *Client, input string) string {
+		var buf bytes.Buffer
+		client.reader, client.decoder = bufio.NewReader(strings.NewReader(input)), nil
+		client.writer = proxy.NewWriter(&buf, 4096)
+		commands, err := client.readPipeline()
+		if err != nil {
+			t.Fatalf("readPipeline() returned error %v", err)
+		}
+		if err := server.pipeline(client, commands); err != nil {
+			t.Fatalf("pipeline() returned error %v", err)
+		}
+		return buf.String()
+	}
+	a, b := &Client{}, &Client{}
+	if reply := run(a, "CLIENT SETNAME worker-1\r\nCLIENT GETNAME\r\nGET k\r\n"); reply != "+OK\r\n$8\r\nworker-1\r\n$3\r\nGET\r\n" {
+		t.Errorf("CLIENT SETNAME and GETNAME wrote %q", reply)
+	}
+	// 名字不会发往共享的后端连接
+	if reply := run(b, "CLIENT GETNAME\r\n"); reply != "$-1\r\n" {
+		t.Errorf("CLIENT GETNAME of another client wrote %q", reply)
+	}
+	if strings.Join(c.sent, " ") != "GET" || a.pinned != nil {
+		t.Errorf("backend got %v, pinned = %v", c.sent, a.pinned != nil)
+	}
+	if reply := run(a, "*3\r\n$6\r\nCLIENT\r\n$7\r\nSETNAME\r\n$8\r\nbad name\r\n"); !strings.HasPrefix(reply, "-ERR Client names cannot contain spaces") || a.name != "worker-1" {
+		t.Errorf("CLIENT SETNAME with a space wrote %q, name = %q", reply, a.name)
+	}
+}
+
+func TestServer_pipelineSessionCommands(t *testing.T) {
+	var buf bytes.Buffer
+	client := &Client{
+		reader: bufio.NewReader(strings.NewReader("AUTH pw\r\nHELLO 3\r\nRESET\r\nPING\r\n")),
+		writer: proxy.NewWriter(&buf, 4096),
+	}
+	f, _ := newFilter(FilterConfig{Mode: "allow", Allow: []string{"AUTH", "HELLO", "RESET", "PING"}})
+	c := &echoConn{}
+	server := &Server{
+		filter: f,
+		router: &singleRouter{pool: &redis.Pool{Dial: func() (redis.Conn, error) { return c, nil }}},
+	}
+	commands, err := client.readPipeline()
+	if err != nil {
+		t.Fatalf("readPipeline() returned error %v", err)
+	}
+	if err := server.pipeline(client, commands); err != nil {
+		t.Fatalf("pipeline() returned error %v", err)
+	}
+	// 共享的后端连接不会被切换用户、协议或者鉴权状态
+	if strings.Join(c.sent, " ") != "PING" {
+		t.Errorf("backend got %v, want only PING", c.sent)
+	}
+	lines := strings.Split(buf.String(), "\r\n")
+	if len(lines) != 5 || !strings.HasPrefix(lines[0], "-ERR AUTH <password> called without any password") ||
+		!strings.HasPrefix(lines[1], "-NOPROTO ") || !strings.HasPrefix(lines[2], "-ERR proxy: RESET") || lines[3] != "+PONG" {
+		t.Errorf("pipeline() wrote %q", buf.String())
+	}
+}
+
+/*
+	routes every command to from, redirects to to
+ */
+type redirectRouter struct {
+	from, to *redis.Pool
+}
+
+func (r *redirectRouter) route(command *proxy.Command) (*redis.Pool, error) { return r.from, nil }
+func (r *redirectRouter) close() error                                     { return nil }
+func (r *redirectRouter) backends() map[string]*redis.Pool {
+	return map[string]*redis.Pool{"from": r.from, "to": r.to}
+}
+
+func (r *redirectRouter) shard(key []byte) (int, *redis.Pool, error) { return 0, r.from, nil }
+func (r *redirectRouter) sharded() bool                               { return false }
+
+func (r *redirectRouter) redirect(err redis.Error) (*redis.Pool, bool) {
+	return r.to, strings.HasPrefix(string(err), "ASK ")
+}
+
+func TestServer_pipelineRedirect(t *testing.T) {
+	for _, tt := range []struct {
+		from, to map[string]redis.Error
+		sent     string
+		reply    string
+	}{
+		{map[string]redis.Error{"GET": "ASK 1 b:1"}, nil, "ASKING GET", "$3\r\nGET\r\n"},
+		{map[string]redis.Error{"GET": "MOVED 1 b:1"}, nil, "GET", "$3\r\nGET\r\n"},
+		{
+			map[string]redis.Error{"GET": "MOVED 1 b:1"},
+			map[string]redis.Error{"GET": "MOVED 1 b:1"},
+			"GET GET",
+			"-MOVED 1 b:1\r\n",
+		},
+	} {
+		var buf bytes.Buffer
+		client := &Client{writer: proxy.NewWriter(&buf, 4096)}
+		from, to := &echoConn{errors: tt.from}, &echoConn{errors: tt.to}
+		config := defaultConfig()
+		config.Backend.MaxRedirects = 2
+		f, _ := newFilter(defaultFilterConfig())
+		server := &Server{
+			config: config,
+			filter: f,
+			router: &redirectRouter{
+				from: &redis.Pool{Dial: func() (redis.Conn, error) { return from, nil }},
+				to: &redis.Pool{Dial: func() (redis.Conn, error) { return to, nil }},
+			},
+		}
+		if err := server.pipeline(client, []*proxy.Command{newCommand("GET", "a")}); err != nil {
+			t.Fatalf("pipeline() returned error %v", err)
+		}
+		if buf.String() != tt.reply {
+			t.Errorf("redirect %v: wrote %q, want %q", tt.from, buf.String(), tt.reply)
+		}
+		if strings.Join(to.sent, " ") != tt.sent {
+			t.Errorf("redirect %v: sent %v, want [%s]", tt.from, to.sent, tt.sent)
+		}
+	}
+}
+
+/*
+	keys starting with a-m live on the first shard, the others on the second
+ */
+type splitRouter struct {
+	pools [2]*redis.Pool
+}
+
+func (r *splitRouter) route(command *proxy.Command) (*redis.Pool, error) {
+	_, pool, err := r.shard(command.Args[0])
+	return pool, err
+}
+
+func (r *splitRouter) shard(key []byte) (int, *redis.Pool, error) {
+	if key[0] <= 'm' {
+		return 0, r.pools[0], nil
+	}
+	return 1, r.pools[1], nil
+}
+
+func (r *splitRouter) sharded() bool                                 { return true }
+func (r *splitRouter) redirect(err redis.Error) (*redis.Pool, bool) { return nil, false }
+func (r *splitRouter) close() error                                 { return nil }
+func (r *splitRouter) backends() map[string]*redis.Pool {
+	return map[string]*redis.Pool{"a-m": r.pools[0], "n-z": r.pools[1]}
+}
+
+func TestServer_pipelineSplit(t *testing.T) {
+	var buf bytes.Buffer
+	client := &Client{writer: proxy.NewWriter(&buf, 4096)}
+	conns := [2]*echoConn{{}, {}}
+	r := &splitRouter{}
+	for i := range r.pools {
+		c := conns[i]
+		r.pools[i] = &redis.Pool{Dial: func() (redis.Conn, error) { return c, nil }}
+	}
+	f, _ := newFilter(defaultFilterConfig())
+	server := &Server{filter: f, router: r}
+	commands := []*proxy.Command{
+		newCommand("MGET", "a", "x", "b", "y"),
+		newCommand("MSET", "a", "1", "x", "2"),
+		newCommand("DEL", "a", "b", "x"),
+		newCommand("EXISTS", "a", "b"),
+	}
+	if err := server.pipeline(client, commands); err != nil {
+		t.Fatalf("pipeline() returned error %v", err)
+	}
+	expected := "*4\r\n$1\r\na\r\n$1\r\nx\r\n$1\r\nb\r\n$1\r\ny\r\n" +
+		"+OK\r\n" +
+		":3\r\n" +
+		":2\r\n"
+	if buf.String() != expected {
+		t.Errorf("pipeline() wrote %q, want %q", buf.String(), expected)
+	}
+	for i, sent := range []string{"MGET MSET DEL EXISTS", "MGET MSET DEL"} {
+		if strings.Join(conns[i].sent, " ") != sent || conns[i].flushed != 1 {
+			t.Errorf("shard %d: sent %v with %d flushes, want [%s] with 1 flush", i, conns[i].sent, conns[i].flushed, sent)
+		}
+	}
+}
+
+func TestServer_pipelineReplicas(t *testing.T) {
+	for _, tt := range []struct {
+		readYourWrites Duration
+		master         string
+		replica        string
+	}{
+		{"", "SET PING", "GET GET"},
+		{"1m", "SET GET PING", "GET"},
+	} {
+		var buf bytes.Buffer
+		client := &Client{writer: proxy.NewWriter(&buf, 4096)}
+		master, replica := &echoConn{}, &echoConn{}
+		config := defaultConfig()
+		config.Backend.ReadYourWrites = tt.readYourWrites
+		f, _ := newFilter(defaultFilterConfig())
+		server := &Server{
+			config: config,
+			filter: f,
+			router: &singleRouter{pool: &redis.Pool{Dial: func() (redis.Conn, error) { return master, nil }}},
+			replicas: &replicaSet{
+				pools: []*redis.Pool{{Dial: func() (redis.Conn, error) { return replica, nil }}},
+				weights: []int{1},
+			},
+		}
+		commands := []*proxy.Command{newCommand("GET", "a"), newCommand("SET", "a", "1"), newCommand("GET", "a"), newCommand("PING")}
+		if err := server.pipeline(client, commands); err != nil {
+			t.Fatalf("pipeline() returned error %v", err)
+		}
+		if strings.Join(master.sent, " ") != tt.master || strings.Join(replica.sent, " ") != tt.replica {
+			t.Errorf("read_your_writes %q: master got %v, replica got %v, want [%s] and [%s]",
+				tt.readYourWrites, master.sent, replica.sent, tt.master, tt.replica)
+		}
+	}
+}
+
+var transactionTests = []struct {
+	commands []string
+	sent     [2]string
+	reply    string
+}{
+	{
+		[]string{"MULTI", "SET a 1", "INCR b", "EXEC"},
+		[2]string{"MULTI SET INCR EXEC", ""},
+		"+OK\r\n$3\r\nSET\r\n:1\r\n*1\r\n:1\r\n",
+	},
+	{
+		[]string{"MULTI", "SET a 1", "SET x 1", "EXEC"},
+		[2]string{"MULTI SET DISCARD", ""},
+		"+OK\r\n$3\r\nSET\r\n-" + string(errCrossShard) + "\r\n-" + string(errExecAbort) + "\r\n",
+	},
+	{
+		[]string{"MULTI", "PING", "EXEC", "MULTI", "EXEC"},
+		[2]string{"", ""},
+		"+OK\r\n-" + string(errNoKey) + "\r\n-" + string(errExecAbort) + "\r\n+OK\r\n*0\r\n",
+	},
+	{
+		[]string{"WATCH x", "MULTI", "GET y", "EXEC", "GET a"},
+		[2]string{"GET", "WATCH MULTI GET EXEC"},
+		"$5\r\nWATCH\r\n+OK\r\n$3\r\nGET\r\n*1\r\n:1\r\n$3\r\nGET\r\n",
+	},
+}
+
+func TestServer_pipelineTransaction(t *testing.T) {
+	for _, tt := range transactionTests {
+		var buf bytes.Buffer
+		client := &Client{writer: proxy.NewWriter(&buf, 4096)}
+		conns := [2]*echoConn{{}, {}}
+		r := &splitRouter{}
+		for i := range r.pools {
+			c := conns[i]
+			r.pools[i] = &redis.Pool{Dial: func() (redis.Conn, error) { return c, nil }}
+		}
+		f, _ := newFilter(defaultFilterConfig())
+		server := &Server{filter: f, router: r}
+		var commands []*proxy.Command
+		for _, command := range tt.commands {
+			commands = append(commands, newCommand(strings.Fields(command)...))
+		}
+		if err := server.pipeline(client, commands); err != nil {
+			t.Fatalf("pipeline(%v) returned error %v", tt.commands, err)
+		}
+		if buf.String() != tt.reply {
+			t.Errorf("pipeline(%v) wrote %q, want %q", tt.commands, buf.String(), tt.reply)
+		}
+		for i := range conns {
+			if sent := strings.Join(conns[i].sent, " "); sent != tt.sent[i] {
+				t.Errorf("pipeline(%v) sent %q to shard %d, want %q", tt.commands, sent, i, tt.sent[i])
+			}
+		}
+		if client.pinned != nil || client.state != 0 || client.shardBound || client.aborted {
+			t.Errorf("pipeline(%v) left the session pinned: %+v", tt.commands, client)
+		}
+	}
+}
+
+func TestServer_pipelineNoScript(t *testing.T) {
+	var buf bytes.Buffer
+	client := &Client{writer: proxy.NewWriter(&buf, 4096)}
+	// 第一个连接没有加载脚本，重试的连接加载后执行
+	conns := []*echoConn{{errors: map[string]redis.Error{"EVALSHA": "NOSCRIPT No matching script. Please use EVAL."}}, {}}
+	dialed := 0
+	pool := &redis.Pool{Dial: func() (redis.Conn, error) { dialed++; return conns[dialed-1], nil }}
+	f, _ := newFilter(defaultFilterConfig())
+	server := &Server{filter: f, router: &singleRouter{pool: pool}}
+	server.scripts.learn(newCommand("SCRIPT", "LOAD", "return 1"))
+
+	commands := []*proxy.Command{
+		newCommand("EVALSHA", "e0e1f9fabfc9d4800c877a703b823ac0578ff8db", "0"),
+		newCommand("EVALSHA", "0000000000000000000000000000000000000000", "0"),
+	}
+	if err := server.pipeline(client, commands); err != nil {
+		t.Fatalf("pipeline() returned error %v", err)
+	}
+	expected := "$7\r\nEVALSHA\r\n-NOSCRIPT No matching script. Please use EVAL.\r\n"
+	if buf.String() != expected {
+		t.Errorf("pipeline() wrote %q, want %q", buf.String(), expected)
+	}
+	if sent := strings.Join(conns[1].sent, " "); sent != "SCRIPT EVALSHA" {
+		t.Errorf("retry sent %q, want SCRIPT EVALSHA", sent)
+	}
+}
+
+/*
+	backend connection that fails on Flush
+ */
+type brokenConn struct {
+	echoConn
+}
+
+func (c *brokenConn) Flush() error { return io.ErrClosedPipe }
+
+func TestServer_logBackendError(t *testing.T) {
+	var buf bytes.Buffer
+	f, _ := newFilter(defaultFilterConfig())
+	server := &Server{
+		config: &Config{},
+		filter: f,
+		router: &singleRouter{address: "10.0.0.1:6379", pool: &redis.Pool{Dial: func() (redis.Conn, error) { return &brokenConn{}, nil }}},
+		log: logger.New(&buf, false, logger.InfoLevel),
+	}
+	server.pubsub = newPubsub(server)
+	conn, backend := net.Pipe()
+	done := make(chan struct{})
+	go func() {
+		server.handleConnection(backend)
+		close(done)
+	}()
+	conn.Write([]byte("GET a\r\n"))
+	// 后端出错只关闭这个客户端的连接
+	if _, err := conn.Read(make([]byte, 16)); err != io.EOF {
+		t.Errorf("Read() returned %v, want io.EOF", err)
+	}
+	<-done
+	expected := `msg="backend connection failed, closing the client connection" client_id=1 remote_addr=pipe backend=10.0.0.1:6379 err="io: read/write on closed pipe"`
+	if !strings.Contains(buf.String(), expected) {
+		t.Errorf("logged %q, want %q", buf.String(), expected)
+	}
+}
@@ -0,0 +1,115 @@
+package module
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+/*
+	graceful shutdown
+	clients waiting for a command are idle, clients running a batch are active:
+	Shutdown closes the listener and the idle clients, active clients are closed
+	once their replies are flushed, the backends are closed when every client is gone
+ */
+
+// returned by Listen after Shutdown
+var ErrServerClosed = errors.New("proxy: server closed")
+
+const shutdownPollInterval = 10 * time.Millisecond
+
+/*
+	register a client, false when the server is shutting down
+ */
+func (server *Server) addClient(client *Client) bool {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if server.closing {
+		return false
+	}
+	if server.clients == nil {
+		server.clients = make(map[*Client]bool)
+	}
+	server.clients[client] = false
+	return true
+}
+
+func (server *Server) removeClient(client *Client) {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	delete(server.clients, client)
+}
+
+/*
+	mark a client active or idle, false when an idle client must stop because of Shutdown
+ */
+func (server *Server) setActive(client *Client, active bool) bool {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if server.closing && !active {
+		return false
+	}
+	server.clients[client] = active
+	return true
+}
+
+/*
+	stop accepting clients, close idle clients, wait for active clients to flush their replies,
+	then close the backend connections
+	when ctx expires first the remaining clients are closed and ctx.Err() is returned
+ */
+func (server *Server) Shutdown(ctx context.Context) error {
+	server.mu.Lock()
+	server.closing = true
+	if server.listener != nil {
+		server.listener.Close()
+	}
+	if server.metricsServer != nil {
+		server.metricsServer.Close()
+	}
+	server.mu.Unlock()
+
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+	var err error
+	for err == nil && server.closeIdle() {
+		select {
+		case <-ctx.Done():
+			server.closeClients()
+			err = ctx.Err()
+		case <-ticker.C:
+		}
+	}
+	server.closeOnce.Do(func() {
+		server.configMu.RLock()
+		defer server.configMu.RUnlock()
+		server.router.close()
+		if server.replicas != nil {
+			server.replicas.close()
+		}
+	})
+	return err
+}
+
+/*
+	close idle clients, returns whether any client is left
+ */
+func (server *Server) closeIdle() bool {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	for client, active := range server.clients {
+		if !active {
+			// 读命令的goroutine随之退出并注销客户端
+			client.conn.Close()
+		}
+	}
+	return len(server.clients) != 0
+}
+
+func (server *Server) closeClients() {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	for client := range server.clients {
+		client.conn.Close()
+	}
+}
@@ -0,0 +1,132 @@
+package module
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"testing"
+	"time"
+	"redisProxy/redis"
+)
+
+/*
+	backend connection whose replies wait for the gate to open
+ */
+type gateConn struct {
+	*echoConn
+	gate	chan struct{}
+}
+
+func (c *gateConn) Receive() (interface{}, error) {
+	<-c.gate
+	return c.echoConn.Receive()
+}
+
+func newShutdownServer(t *testing.T, c redis.Conn) (*Server, string, chan error) {
+	f, _ := newFilter(defaultFilterConfig())
+	server := &Server{
+		address: "127.0.0.1:0",
+		config: &Config{},
+		filter: f,
+		router: &singleRouter{pool: &redis.Pool{Dial: func() (redis.Conn, error) { return c, nil }}},
+	}
+	server.pubsub = newPubsub(server)
+	errc := make(chan error, 1)
+	go func() { errc <- server.Listen() }()
+	for i := 0; i < 100; i++ {
+		server.mu.Lock()
+		l := server.listener
+		server.mu.Unlock()
+		if l != nil {
+			return server, l.Addr().String(), errc
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("server did not start listening")
+	return nil, "", nil
+}
+
+func waitClients(t *testing.T, server *Server, n int) {
+	for i := 0; i < 100; i++ {
+		server.mu.Lock()
+		count := len(server.clients)
+		server.mu.Unlock()
+		if count == n {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("server did not register %d clients", n)
+}
+
+func TestServer_Shutdown(t *testing.T) {
+	gate := make(chan struct{})
+	server, address, errc := newShutdownServer(t, &gateConn{echoConn: &echoConn{}, gate: gate})
+	idle, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer idle.Close()
+	active, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer active.Close()
+	waitClients(t, server, 2)
+	active.Write([]byte("GET a\r\n"))
+	time.Sleep(50 * time.Millisecond)
+
+	done := make(chan error, 1)
+	go func() { done <- server.Shutdown(context.Background()) }()
+
+	// 空闲客户端立即关闭
+	idle.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := idle.Read(make([]byte, 1)); err == nil {
+		t.Error("idle client was not closed")
+	}
+	if err := <-errc; err != ErrServerClosed {
+		t.Errorf("Listen() = %v, want %v", err, ErrServerClosed)
+	}
+	if _, err := net.Dial("tcp", address); err == nil {
+		t.Error("server accepted a client after Shutdown")
+	}
+	select {
+	case err := <-done:
+		t.Fatalf("Shutdown() = %v before the active client got its reply", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(gate)
+	r := bufio.NewReader(active)
+	active.SetReadDeadline(time.Now().Add(time.Second))
+	if line, err := r.ReadString('\n'); err != nil || line != "$3\r\n" {
+		t.Errorf("active client read %q, %v, want the GET reply", line, err)
+	}
+	if err := <-done; err != nil {
+		t.Errorf("Shutdown() = %v", err)
+	}
+}
+
+func TestServer_ShutdownTimeout(t *testing.T) {
+	gate := make(chan struct{})
+	defer close(gate)
+	server, address, _ := newShutdownServer(t, &gateConn{echoConn: &echoConn{}, gate: gate})
+	active, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer active.Close()
+	waitClients(t, server, 1)
+	active.Write([]byte("GET a\r\n"))
+	time.Sleep(50 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Shutdown() = %v, want %v", err, context.DeadlineExceeded)
+	}
+	active.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := active.Read(make([]byte, 1)); err == nil {
+		t.Error("active client was not closed when the context expired")
+	}
+}
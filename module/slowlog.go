@@ -0,0 +1,231 @@
+package module
+
+import (
+	"fmt"
+	"sync"
+	"time"
+	"redisProxy/proxy"
+	"redisProxy/redis"
+)
+
+/*
+	slow command log, see Config.Slowlog and PROXY SLOWLOG
+	unlike the redis SLOWLOG the latency is measured in the proxy, from the start of the batch
+	to the flush of its replies to the client, and split into
+	queue: waiting for the config lock and backend connections, until the batch is flushed to the backends
+	backend: from the flush to the reply of the command, including the replies queued before it
+	write: from the reply of the command to the flush of the replies of the batch to the client
+	pub/sub, blocking and PROXY commands are not logged
+ */
+
+const (
+	slowlogMaxArgs		= 32	// 与redis相同，多余的参数合并为一个
+	slowlogMaxArgLen	= 128
+)
+
+type slowlog struct {
+	mu	sync.Mutex
+	entries	[]slowEntry	// 环形缓冲区，start为最早的记录
+	start	int
+	lastID	int64
+}
+
+type slowEntry struct {
+	id		int64
+	time		time.Time
+	client		string
+	args		[][]byte
+	node		string
+	queue		time.Duration
+	backend		time.Duration
+	write		time.Duration
+}
+
+func (e *slowEntry) total() time.Duration {
+	return e.queue + e.backend + e.write
+}
+
+/*
+	timestamps of a pipelined batch, see Server.roundTrip
+	received is zero and pools nil for the commands answered by the proxy
+ */
+type batchTrace struct {
+	begin		time.Time
+	flushed		time.Time
+	received	[]time.Time
+	pools		[][]*redis.Pool
+}
+
+func newBatchTrace(begin time.Time, n int) *batchTrace {
+	return &batchTrace{
+		begin: begin,
+		received: make([]time.Time, n),
+		pools: make([][]*redis.Pool, n),
+	}
+}
+
+/*
+	add an entry, the oldest entries are dropped beyond maxLen
+ */
+func (s *slowlog) add(entry slowEntry, maxLen int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastID++
+	entry.id = s.lastID
+	if len(s.entries) == maxLen {
+		s.entries[s.start] = entry
+		s.start = (s.start + 1) % maxLen
+		return
+	}
+	if s.start != 0 || len(s.entries) > maxLen {
+		// max_len被Reload修改，按时间顺序展开环形缓冲区
+		entries := make([]slowEntry, 0, maxLen)
+		entries = append(entries, s.entries[s.start:]...)
+		entries = append(entries, s.entries[:s.start]...)
+		if len(entries) >= maxLen {
+			entries = entries[len(entries)-maxLen+1:]
+		}
+		s.entries = entries
+		s.start = 0
+	}
+	s.entries = append(s.entries, entry)
+}
+
+/*
+	at most n entries, newest first, all entries when n is negative
+ */
+func (s *slowlog) get(n int) []slowEntry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if n < 0 || n > len(s.entries) {
+		n = len(s.entries)
+	}
+	entries := make([]slowEntry, n)
+	for i := range entries {
+		entries[i] = s.entries[(s.start+len(s.entries)-1-i)%len(s.entries)]
+	}
+	return entries
+}
+
+func (s *slowlog) len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.entries)
+}
+
+func (s *slowlog) reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries = nil
+	s.start = 0
+}
+
+/*
+	log the commands of a batch slower than the threshold, their replies were flushed at written
+ */
+func (server *Server) logSlow(client *Client, commands []*proxy.Command, trace *batchTrace, written time.Time) {
+	server.configMu.RLock()
+	defer server.configMu.RUnlock()
+	config := server.config.Slowlog
+	threshold := config.Threshold.value()
+	if config.Threshold == "" || written.Sub(trace.begin) < threshold {
+		return
+	}
+	var addr string
+	if client.conn != nil {
+		addr = client.conn.RemoteAddr().String()
+	}
+	var nodes map[*redis.Pool]string
+	for i, command := range commands {
+		entry := slowEntry{
+			time: trace.begin,
+			client: addr,
+			args: slowArgs(command),
+			queue: trace.flushed.Sub(trace.begin),
+			write: written.Sub(trace.flushed),
+		}
+		if trace.flushed.IsZero() {
+			// 整批命令都由代理回复
+			entry.queue, entry.write = 0, written.Sub(trace.begin)
+		}
+		if !trace.received[i].IsZero() {
+			entry.backend = trace.received[i].Sub(trace.flushed)
+			entry.write = written.Sub(trace.received[i])
+		}
+		if entry.total() < threshold {
+			continue
+		}
+		if nodes == nil {
+			nodes = server.nodes()
+		}
+		for j, pool := range trace.pools[i] {
+			if j > 0 {
+				entry.node += ","
+			}
+			entry.node += nodes[pool]
+		}
+		server.slowlog.add(entry, config.MaxLen)
+		if config.Log {
+			client.log.Info("slow command", "backend", entry.node, "command", fmt.Sprintf("%q", entry.args),
+				"total_time", entry.total(), "queue_time", entry.queue, "backend_time", entry.backend, "write_time", entry.write)
+		}
+	}
+}
+
+/*
+	pools of the parts of a command
+ */
+func partPools(client *Client, parts []*part) []*redis.Pool {
+	pools := make([]*redis.Pool, len(parts))
+	for i, p := range parts {
+		pools[i] = client.partPool(p)
+	}
+	return pools
+}
+
+/*
+	command name and arguments truncated like the redis SLOWLOG,
+	copied as the arguments share the read buffer of the client
+ */
+func slowArgs(command *proxy.Command) [][]byte {
+	args := append([][]byte{command.Name}, command.Args...)
+	if len(args) > slowlogMaxArgs {
+		more := []byte(fmt.Sprintf("... (%d more arguments)", len(args)-slowlogMaxArgs+1))
+		args = append(args[:slowlogMaxArgs-1:slowlogMaxArgs-1], more)
+	}
+	truncated := make([][]byte, len(args))
+	for i, arg := range args {
+		if len(arg) > slowlogMaxArgLen {
+			truncated[i] = append(append([]byte(nil), arg[:slowlogMaxArgLen]...), fmt.Sprintf("... (%d more bytes)", len(arg)-slowlogMaxArgLen)...)
+		} else {
+			truncated[i] = append([]byte(nil), arg...)
+		}
+	}
+	return truncated
+}
+
+/*
+	reply of PROXY SLOWLOG GET, one array per entry:
+	id, unix time, total, queue, backend and write microseconds, arguments, client address, backend node
+ */
+func slowlogReply(entries []slowEntry) []interface{} {
+	reply := make([]interface{}, len(entries))
+	for i, e := range entries {
+		args := make([]interface{}, len(e.args))
+		for j, arg := range e.args {
+			args[j] = arg
+		}
+		reply[i] = []interface{}{
+			e.id,
+			e.time.Unix(),
+			int64(e.total() / time.Microsecond),
+			int64(e.queue / time.Microsecond),
+			int64(e.backend / time.Microsecond),
+			int64(e.write / time.Microsecond),
+			args,
+			[]byte(e.client),
+			[]byte(e.node),
+		}
+	}
+	return reply
+}
@@ -0,0 +1,126 @@
+package module
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+	"redisProxy/proxy"
+	"redisProxy/redis"
+)
+
+func TestSlowlog_ring(t *testing.T) {
+	var s slowlog
+	for i := 0; i < 5; i++ {
+		s.add(slowEntry{client: fmt.Sprint(i)}, 3)
+	}
+	ids := func(entries []slowEntry) string {
+		var ids []string
+		for _, e := range entries {
+			ids = append(ids, fmt.Sprintf("%d:%s", e.id, e.client))
+		}
+		return strings.Join(ids, " ")
+	}
+	if got := ids(s.get(-1)); got != "5:4 4:3 3:2" {
+		t.Errorf("get(-1) = %s, want the 3 newest entries", got)
+	}
+	if got := ids(s.get(2)); got != "5:4 4:3" {
+		t.Errorf("get(2) = %s", got)
+	}
+	// max_len被Reload修改
+	s.add(slowEntry{client: "5"}, 4)
+	s.add(slowEntry{client: "6"}, 4)
+	if got := ids(s.get(-1)); got != "7:6 6:5 5:4 4:3" {
+		t.Errorf("get(-1) after growing = %s", got)
+	}
+	s.add(slowEntry{client: "7"}, 2)
+	if got := ids(s.get(-1)); got != "8:7 7:6" {
+		t.Errorf("get(-1) after shrinking = %s", got)
+	}
+	s.reset()
+	if s.len() != 0 {
+		t.Errorf("len() after reset() = %d", s.len())
+	}
+}
+
+func TestSlowArgs(t *testing.T) {
+	args := make([]string, 40)
+	for i := range args {
+		args[i] = "k"
+	}
+	args[1] = strings.Repeat("v", 130)
+	truncated := slowArgs(newCommand(append([]string{"MSET"}, args...)...))
+	if len(truncated) != slowlogMaxArgs {
+		t.Fatalf("slowArgs() returned %d arguments, want %d", len(truncated), slowlogMaxArgs)
+	}
+	if s := string(truncated[2]); s != strings.Repeat("v", 128)+"... (2 more bytes)" {
+		t.Errorf("long argument truncated to %q", s)
+	}
+	if s := string(truncated[31]); s != "... (10 more arguments)" {
+		t.Errorf("last argument = %q", s)
+	}
+}
+
+/*
+	backend connection that takes delay to answer
+ */
+type slowConn struct {
+	echoConn
+	delay time.Duration
+}
+
+func (c *slowConn) Flush() error {
+	time.Sleep(c.delay)
+	return c.echoConn.Flush()
+}
+
+func TestServer_slowlog(t *testing.T) {
+	config := &Config{
+		Backend: BackendConfig{Addresses: []string{"10.0.0.1:6379"}},
+		Slowlog: SlowlogConfig{Threshold: "20ms", MaxLen: 8},
+	}
+	c := &slowConn{delay: 30 * time.Millisecond}
+	server := &Server{
+		config: config,
+		router: &singleRouter{address: "10.0.0.1:6379", pool: &redis.Pool{Dial: func() (redis.Conn, error) { return c, nil }}},
+	}
+	server.filter, _ = newFilter(defaultFilterConfig())
+	server.pubsub = newPubsub(server)
+
+	var buf bytes.Buffer
+	client := &Client{
+		server: server,
+		reader: bufio.NewReader(strings.NewReader("GET a\r\nPING\r\nPROXY SLOWLOG GET\r\nPROXY SLOWLOG LEN\r\n")),
+		writer: proxy.NewWriter(&buf, 4096),
+	}
+	commands, err := client.readPipeline()
+	if err != nil {
+		t.Fatalf("readPipeline() returned error %v", err)
+	}
+	if err := server.execute(client, commands); err != nil {
+		t.Fatalf("execute() returned error %v", err)
+	}
+	entries := server.slowlog.get(-1)
+	if len(entries) != 2 {
+		t.Fatalf("slowlog has %d entries, want 2", len(entries))
+	}
+	e := entries[1]
+	if string(bytes.Join(e.args, []byte(" "))) != "GET a" || e.node != "10.0.0.1:6379" || e.id != 1 {
+		t.Errorf("entry = %+v", e)
+	}
+	if e.queue < c.delay {
+		t.Errorf("queue = %v, backend = %v, write = %v, want the flush in queue", e.queue, e.backend, e.write)
+	}
+	if !strings.HasSuffix(buf.String(), ":2\r\n") || !strings.Contains(buf.String(), "$3\r\nGET\r\n$1\r\na\r\n$0\r\n\r\n$13\r\n10.0.0.1:6379\r\n") {
+		t.Errorf("PROXY SLOWLOG wrote %q", buf.String())
+	}
+
+	// 低于阈值的命令不记录
+	c.delay = 0
+	server.lockedPipeline(client, []*proxy.Command{newCommand("GET", "b")})
+	if server.slowlog.len() != 2 {
+		t.Errorf("fast command was logged")
+	}
+}
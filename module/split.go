@@ -0,0 +1,104 @@
+package module
+
+import (
+	"redisProxy/proxy"
+	"redisProxy/redis"
+)
+
+/*
+	multi-key commands split by shard: command -> number of arguments per key
+	MSETNX is not split, it must stay atomic
+ */
+var splitCommands = map[string]int{
+	"MGET":   1,
+	"MSET":   2,
+	"DEL":    1,
+	"UNLINK": 1,
+	"EXISTS": 1,
+	"TOUCH":  1,
+}
+
+/*
+	a command forwarded on one backend connection,
+	a split command has one part per shard
+ */
+type part struct {
+	conn		redis.Conn
+	pool		*redis.Pool	// 固定连接上的命令为nil
+	command		*proxy.Command
+	positions	[]int	// 拆分命令中各key在原命令中的序号
+	redirectable	bool	// 有状态会话中的命令不跟随重定向
+	multi		bool	// 先发送延迟的MULTI，丢弃其回复
+	reply		interface{}	// 代替后端回复返回给客户端
+}
+
+/*
+	split a multi-key command whose keys live on different shards,
+	nil when the command is not split
+ */
+func (server *Server) split(command *proxy.Command) ([]*part, error) {
+	step, ok := splitCommands[command.CommandName()]
+	if !ok || len(command.Args) <= step || len(command.Args)%step != 0 {
+		return nil, nil
+	}
+	var parts []*part
+	shards := make(map[int]*part)
+	for i := 0; i < len(command.Args); i += step {
+		shard, pool, err := server.router.shard(command.Args[i])
+		if err != nil {
+			return nil, err
+		}
+		p, ok := shards[shard]
+		if !ok {
+			p = &part{
+				pool: pool,
+				command: &proxy.Command{Name: command.Name},
+				redirectable: true,
+			}
+			shards[shard] = p
+			parts = append(parts, p)
+		}
+		p.command.Args = append(p.command.Args, command.Args[i:i+step]...)
+		p.positions = append(p.positions, i/step)
+	}
+	if len(parts) == 1 {
+		// 所有key在同一个分片，原样转发
+		return nil, nil
+	}
+	return parts, nil
+}
+
+/*
+	merge the replies of a split command as a single redis would reply:
+	MGET values in key order, MSET OK, DEL/UNLINK/EXISTS/TOUCH the sum
+	the first error of a part is the reply of the command
+ */
+func merge(command *proxy.Command, parts []*part, replies []interface{}) interface{} {
+	for _, reply := range replies {
+		if err, ok := reply.(error); ok {
+			return err
+		}
+	}
+	switch command.CommandName() {
+	case "MGET":
+		merged := make([]interface{}, len(command.Args))
+		for i, p := range parts {
+			values, _ := replies[i].([]interface{})
+			for j, position := range p.positions {
+				if j < len(values) {
+					merged[position] = values[j]
+				}
+			}
+		}
+		return merged
+	case "MSET":
+		return "OK"
+	default:
+		var n int64
+		for _, reply := range replies {
+			v, _ := reply.(int64)
+			n += v
+		}
+		return n
+	}
+}
@@ -10,9 +10,11 @@ const address  = "xxxx"
 
 func New(address string) *Server{
 	log.Println("Creating server with address", address)
-	server := &Server{
-		address:address,
-	}
+	config := defaultConfig()
+	config.Listen = address
+	config.Backend.Addresses = []string{"127.0.0.1:6379"}
+	config.Filter = defaultFilterConfig()
+	server, _ := NewServer(config)
 	return server
 }
 
@@ -0,0 +1,106 @@
+package module
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"redisProxy/redis"
+)
+
+/*
+	TLS termination of client connections and TLS dialing of backends
+	certificates are loaded once by Config.validate
+ */
+
+/*
+	load the listener certificate, no-op when TLS is disabled
+ */
+func (config *ListenTLSConfig) load() error {
+	config.config = nil
+	if config.CertFile == "" && config.KeyFile == "" {
+		if config.ClientCAFile != "" {
+			return &ConfigError{Key: "client_ca_file", Err: fmt.Errorf("requires cert_file and key_file")}
+		}
+		return nil
+	}
+	cert, err := loadKeyPair(config.CertFile, config.KeyFile)
+	if err != nil {
+		return err
+	}
+	c := &tls.Config{Certificates: []tls.Certificate{cert}}
+	if config.ClientCAFile != "" {
+		pool, err := loadCertPool(config.ClientCAFile)
+		if err != nil {
+			return &ConfigError{Key: "client_ca_file", Err: err}
+		}
+		c.ClientCAs = pool
+		c.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	config.config = c
+	return nil
+}
+
+/*
+	load the CA bundle and client certificate of backend connections
+ */
+func (config *BackendTLSConfig) load() error {
+	config.config = nil
+	if !config.Enabled {
+		return nil
+	}
+	c := &tls.Config{ServerName: config.ServerName, InsecureSkipVerify: config.SkipVerify}
+	if config.CAFile != "" {
+		pool, err := loadCertPool(config.CAFile)
+		if err != nil {
+			return &ConfigError{Key: "ca_file", Err: err}
+		}
+		c.RootCAs = pool
+	}
+	if config.CertFile != "" || config.KeyFile != "" {
+		cert, err := loadKeyPair(config.CertFile, config.KeyFile)
+		if err != nil {
+			return err
+		}
+		c.Certificates = []tls.Certificate{cert}
+	}
+	config.config = c
+	return nil
+}
+
+func (config *BackendTLSConfig) dialOptions() []redis.DialOption {
+	if !config.Enabled {
+		return nil
+	}
+	return []redis.DialOption{
+		redis.DialUseTLS(true),
+		redis.DialTLSConfig(config.config),
+		redis.DialTLSSkipVerify(config.SkipVerify),
+	}
+}
+
+func loadKeyPair(certFile, keyFile string) (tls.Certificate, error) {
+	if certFile == "" {
+		return tls.Certificate{}, &ConfigError{Key: "cert_file", Err: fmt.Errorf("required with key_file")}
+	}
+	if keyFile == "" {
+		return tls.Certificate{}, &ConfigError{Key: "key_file", Err: fmt.Errorf("required with cert_file")}
+	}
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return tls.Certificate{}, &ConfigError{Key: "cert_file", Err: err}
+	}
+	return cert, nil
+}
+
+func loadCertPool(file string) (*x509.CertPool, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no PEM certificate in %s", file)
+	}
+	return pool, nil
+}
@@ -0,0 +1,163 @@
+package module
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+	"redisProxy/redis"
+)
+
+/*
+	test certificates signed by a throwaway CA, written to dir as <name>.pem and <name>-key.pem
+ */
+type testCA struct {
+	dir	string
+	cert	*x509.Certificate
+	key	*ecdsa.PrivateKey
+	serial	int64
+}
+
+func newTestCA(t *testing.T) *testCA {
+	ca := &testCA{dir: t.TempDir()}
+	ca.cert, ca.key = ca.issue(t, "ca", nil)
+	return ca
+}
+
+/*
+	issue a certificate for 127.0.0.1, self-signed when ca.cert is nil
+ */
+func (ca *testCA) issue(t *testing.T, name string, usage []x509.ExtKeyUsage) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca.serial++
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(ca.serial),
+		Subject: pkix.Name{CommonName: name},
+		NotBefore: time.Now().Add(-time.Hour),
+		NotAfter: time.Now().Add(time.Hour),
+		IPAddresses: []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage: usage,
+	}
+	parent, signer := template, key
+	if ca.cert == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage = x509.KeyUsageCertSign
+	} else {
+		parent, signer = ca.cert, ca.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ioutil.WriteFile(ca.file(name), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	ioutil.WriteFile(ca.file(name+"-key"), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600)
+	cert, _ := x509.ParseCertificate(der)
+	return cert, key
+}
+
+func (ca *testCA) file(name string) string {
+	return filepath.Join(ca.dir, name+".pem")
+}
+
+/*
+	TLS server replying +PONG to every line
+ */
+func servePong(t *testing.T, config *tls.Config) net.Listener {
+	l, err := tls.Listen("tcp", "127.0.0.1:0", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				r := bufio.NewReader(c)
+				for {
+					line, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					// 只回复命令的第一行
+					if line[0] == '*' {
+						c.Write([]byte("+PONG\r\n"))
+					}
+				}
+			}()
+		}
+	}()
+	return l
+}
+
+func dialTLS(t *testing.T, address string, config BackendTLSConfig) (interface{}, error) {
+	if err := config.load(); err != nil {
+		t.Fatalf("load() returned error %v", err)
+	}
+	backend := BackendConfig{ConnectTimeout: "1s", ReadTimeout: "1s", TLS: config}
+	c, err := redis.Dial("tcp", address, backend.dialOptions()...)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+	return c.Do("PING")
+}
+
+func TestTLS(t *testing.T) {
+	ca := newTestCA(t)
+	ca.issue(t, "server", []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth})
+	ca.issue(t, "client", []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth})
+	listen := ListenTLSConfig{CertFile: ca.file("server"), KeyFile: ca.file("server-key")}
+	if err := listen.load(); err != nil {
+		t.Fatalf("load() returned error %v", err)
+	}
+	l := servePong(t, listen.config)
+	defer l.Close()
+	listen.ClientCAFile = ca.file("ca")
+	if err := listen.load(); err != nil {
+		t.Fatalf("load() returned error %v", err)
+	}
+	mutual := servePong(t, listen.config)
+	defer mutual.Close()
+
+	tests := []struct {
+		address	string
+		config	BackendTLSConfig
+		ok	bool
+	}{
+		{l.Addr().String(), BackendTLSConfig{Enabled: true, CAFile: ca.file("ca")}, true},
+		{l.Addr().String(), BackendTLSConfig{Enabled: true}, false},
+		{l.Addr().String(), BackendTLSConfig{Enabled: true, SkipVerify: true}, true},
+		{l.Addr().String(), BackendTLSConfig{Enabled: true, CAFile: ca.file("ca"), ServerName: "redis.example.com"}, false},
+		{mutual.Addr().String(), BackendTLSConfig{Enabled: true, CAFile: ca.file("ca")}, false},
+		{mutual.Addr().String(), BackendTLSConfig{Enabled: true, CAFile: ca.file("ca"), CertFile: ca.file("client"), KeyFile: ca.file("client-key")}, true},
+	}
+	for i, tt := range tests {
+		reply, err := dialTLS(t, tt.address, tt.config)
+		if tt.ok && (err != nil || reply != "PONG") {
+			t.Errorf("%d: PING = %v, %v, want PONG", i, reply, err)
+		} else if !tt.ok && err == nil {
+			t.Errorf("%d: PING = %v, want an error", i, reply)
+		}
+	}
+}
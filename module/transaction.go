@@ -0,0 +1,134 @@
+package module
+
+import (
+	"redisProxy/internal"
+	"redisProxy/proxy"
+	"redisProxy/redis"
+)
+
+/*
+	transactions (MULTI/EXEC/WATCH) run on the pinned connection of the client
+	in sharded modes MULTI has no key to route by, the proxy replies OK itself
+	and sends MULTI along with the first keyed command of the transaction,
+	all keys of the session must then hash to the shard of the pinned connection
+ */
+
+var (
+	errExecAbort	= redis.Error("EXECABORT Transaction discarded because of previous errors.")
+	errNestedMulti	= redis.Error("ERR MULTI calls can not be nested")
+	errCrossShard	= redis.Error("ERR proxy: keys of a transaction must hash to the same shard")
+	errNoKey	= redis.Error("ERR proxy: the first command of a transaction must have a key")
+)
+
+/*
+	parts or local reply of a command inside a transaction,
+	handled is false for commands outside of transactions
+ */
+func (server *Server) transaction(client *Client, command *proxy.Command, batch map[*redis.Pool]redis.Conn) (parts []*part, reply interface{}, handled bool) {
+	name := command.CommandName()
+	multi := client.state&internal.MultiState != 0
+	switch {
+	case name == "MULTI" && client.pinned == nil && server.router.sharded():
+		if multi {
+			return nil, errNestedMulti, true
+		}
+		client.state |= internal.MultiState
+		return nil, "OK", true
+	case multi && client.pinned == nil:
+		// MULTI尚未发送到后端
+		return server.deferred(client, command, batch)
+	case client.pinned != nil:
+		if server.router.sharded() {
+			if err := server.bindShard(client, command); err != nil {
+				return nil, server.abort(client, err), true
+			}
+		}
+		if name == "EXEC" && client.aborted {
+			discard := &proxy.Command{Name: []byte("DISCARD")}
+			return []*part{{conn: client.pinned, command: discard, reply: errExecAbort}}, nil, true
+		}
+	}
+	return nil, nil, false
+}
+
+/*
+	command of a transaction whose MULTI was not sent yet
+ */
+func (server *Server) deferred(client *Client, command *proxy.Command, batch map[*redis.Pool]redis.Conn) ([]*part, interface{}, bool) {
+	switch command.CommandName() {
+	case "MULTI":
+		return nil, errNestedMulti, true
+	case "EXEC":
+		aborted := client.aborted
+		client.state &^= internal.MultiState
+		client.aborted = false
+		if aborted {
+			return nil, errExecAbort, true
+		}
+		return nil, []interface{}{}, true
+	case "DISCARD":
+		client.state &^= internal.MultiState
+		client.aborted = false
+		return nil, "OK", true
+	}
+	shard, ok, err := server.commandShard(command)
+	if err != nil {
+		return nil, server.abort(client, err), true
+	}
+	if !ok {
+		return nil, server.abort(client, errNoKey), true
+	}
+	pool, err := server.router.route(command)
+	if err != nil {
+		return nil, server.abort(client, err), true
+	}
+	c, err := server.batchConn(pool, batch)
+	if err != nil {
+		return nil, server.abort(client, err), true
+	}
+	client.shard, client.shardBound = shard, true
+	return []*part{{conn: c, pool: pool, command: command, multi: true}}, nil, true
+}
+
+/*
+	bind the session to the shard of the command keys, or check the keys hash to it
+ */
+func (server *Server) bindShard(client *Client, command *proxy.Command) error {
+	shard, ok, err := server.commandShard(command)
+	if err != nil || !ok {
+		return err
+	}
+	if !client.shardBound {
+		client.shard, client.shardBound = shard, true
+	} else if shard != client.shard {
+		return errCrossShard
+	}
+	return nil
+}
+
+/*
+	shard of the command keys, ok is false for commands without keys
+ */
+func (server *Server) commandShard(command *proxy.Command) (shard int, ok bool, err error) {
+	for i, key := range commandKeys(command) {
+		s, _, err := server.router.shard(key)
+		if err != nil {
+			return 0, false, err
+		}
+		if i > 0 && s != shard {
+			return 0, false, errCrossShard
+		}
+		shard, ok = s, true
+	}
+	return shard, ok, nil
+}
+
+/*
+	a command rejected by the proxy inside MULTI makes EXEC fail, as redis does
+ */
+func (server *Server) abort(client *Client, err error) error {
+	if client.state&internal.MultiState != 0 {
+		client.aborted = true
+	}
+	return err
+}
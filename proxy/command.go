@@ -0,0 +1,153 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"strings"
+)
+
+const (
+	maxMultiBulkLen	= 1024 * 1024		// 单条命令参数个数上限，与redis-server一致
+	maxBulkLen	= 512 * 1024 * 1024	// 单个参数字节数上限，与redis-server一致
+	bulkPreallocLen	= 64 * 1024		// 不超过该长度的参数按声明的长度一次分配
+)
+
+/*
+*	redis客户端发送的一条完整命令
+*	Name: 命令名；Args: 参数列表，均为二进制安全的[]byte
+ */
+type Command struct {
+	Name	[]byte
+	Args	[][]byte
+}
+
+/*
+*	大写的命令名，用于过滤、路由等判断
+ */
+func (command *Command) CommandName() string {
+	return strings.ToUpper(string(command.Name))
+}
+
+/*
+*	流式RESP解码器：从客户端连接中逐条组装完整的multi-bulk命令
+ */
+type Decoder struct {
+	br	*bufio.Reader
+}
+
+/*
+*	创建解码器，size为读缓冲区大小
+ */
+func NewDecoder(r io.Reader, size int) *Decoder {
+	return &Decoder{
+		br: bufio.NewReaderSize(r, size),
+	}
+}
+
+/*
+*	读缓冲区中尚未解码的字节数，大于0说明客户端还有pipeline中的命令
+ */
+func (decoder *Decoder) Buffered() int {
+	return decoder.br.Buffered()
+}
+
+/*
+*	读取一行数据，去掉末尾的\r\n
+ */
+func (decoder *Decoder) readLine() ([]byte, error) {
+	p, err := decoder.br.ReadSlice('\n')
+	if err == bufio.ErrBufferFull {
+		return nil, protocolError("too big request line")
+	}
+	if err != nil {
+		return nil, err
+	}
+	i := len(p) - 2
+	if i < 0 || p[i] != '\r' {
+		return nil, protocolError("bad request line terminator")
+	}
+	return p[:i], nil
+}
+
+/*
+*	读取一个bulk string参数，参数中可以包含\r\n
+ */
+func (decoder *Decoder) readBulk() ([]byte, error) {
+	line, err := decoder.readLine()
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 || line[0] != '$' {
+		return nil, protocolError("expected '$'")
+	}
+	n, err := parseLen(line[1:])
+	if err != nil || n < 0 || n > maxBulkLen {
+		return nil, protocolError("invalid bulk length")
+	}
+	var p []byte
+	if n <= bulkPreallocLen {
+		p = make([]byte, n+2)
+		if _, err := io.ReadFull(decoder.br, p); err != nil {
+			return nil, err
+		}
+	} else {
+		// 大参数随读到的数据增长，声明的长度不直接分配内存
+		b := bytes.NewBuffer(make([]byte, 0, bulkPreallocLen))
+		if _, err := io.CopyN(b, decoder.br, int64(n+2)); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		p = b.Bytes()
+	}
+	if p[n] != '\r' || p[n+1] != '\n' {
+		return nil, protocolError("bad bulk string format")
+	}
+	return p[:n], nil
+}
+
+/*
+*	解码一条完整命令，*0和*-1这类空命令以及空行会被跳过
+*	不以'*'开头的行按inline命令解析，如telnet/nc中输入的 GET foo
+ */
+func (decoder *Decoder) Decode() (*Command, error) {
+	for {
+		p, err := decoder.br.ReadSlice('\n')
+		if err == bufio.ErrBufferFull {
+			return nil, protocolError("too big request line")
+		}
+		if err != nil {
+			return nil, err
+		}
+		if p[0] != '*' {
+			args, err := ParseInline(p)
+			if err != nil {
+				return nil, err
+			}
+			if len(args) == 0 {
+				continue
+			}
+			return &Command{Name: args[0], Args: args[1:]}, nil
+		}
+		i := len(p) - 2
+		if i < 0 || p[i] != '\r' {
+			return nil, protocolError("bad request line terminator")
+		}
+		n, err := parseLen(p[1:i])
+		if err != nil || n > maxMultiBulkLen {
+			return nil, protocolError("invalid multibulk length")
+		}
+		if n <= 0 {
+			continue
+		}
+		args := make([][]byte, n)
+		for i := range args {
+			if args[i], err = decoder.readBulk(); err != nil {
+				return nil, err
+			}
+		}
+		return &Command{Name: args[0], Args: args[1:]}, nil
+	}
+}
@@ -0,0 +1,96 @@
+package proxy
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var decodeTests = []struct {
+	request  string
+	expected []*Command
+}{
+	{
+		"*3\r\n$3\r\nSET\r\n$3\r\nkey\r\n$5\r\nvalue\r\n",
+		[]*Command{{Name: []byte("SET"), Args: [][]byte{[]byte("key"), []byte("value")}}},
+	},
+	{
+		// bulk string with embedded CRLF
+		"*3\r\n$3\r\nSET\r\n$3\r\nkey\r\n$6\r\nva\r\nue\r\n",
+		[]*Command{{Name: []byte("SET"), Args: [][]byte{[]byte("key"), []byte("va\r\nue")}}},
+	},
+	{
+		// empty multi bulk is skipped
+		"*0\r\n*1\r\n$4\r\nPING\r\n",
+		[]*Command{{Name: []byte("PING"), Args: [][]byte{}}},
+	},
+	{
+		// argument longer than the preallocated buffer
+		"*2\r\n$4\r\nECHO\r\n$70000\r\n" + strings.Repeat("x", 70000) + "\r\n",
+		[]*Command{{Name: []byte("ECHO"), Args: [][]byte{[]byte(strings.Repeat("x", 70000))}}},
+	},
+	{
+		// pipelined commands
+		"*2\r\n$3\r\nGET\r\n$1\r\na\r\n*2\r\n$3\r\nGET\r\n$0\r\n\r\n",
+		[]*Command{
+			{Name: []byte("GET"), Args: [][]byte{[]byte("a")}},
+			{Name: []byte("GET"), Args: [][]byte{[]byte("")}},
+		},
+	},
+	{
+		// inline commands, blank lines are skipped
+		"PING\r\n\r\nGET foo\n",
+		[]*Command{
+			{Name: []byte("PING"), Args: [][]byte{}},
+			{Name: []byte("GET"), Args: [][]byte{[]byte("foo")}},
+		},
+	},
+	{
+		"SET \"a b\" 'it\\'s' \"\\x41\\n\" \"\"\r\n",
+		[]*Command{{Name: []byte("SET"), Args: [][]byte{[]byte("a b"), []byte("it's"), []byte("A\n"), []byte("")}}},
+	},
+}
+
+func TestDecode(t *testing.T) {
+	for _, tt := range decodeTests {
+		decoder := NewDecoder(strings.NewReader(tt.request), 4096)
+		for i, expected := range tt.expected {
+			actual, err := decoder.Decode()
+			if err != nil {
+				t.Errorf("Decode(%q) #%d returned error %v", tt.request, i, err)
+				break
+			}
+			if !reflect.DeepEqual(actual, expected) {
+				t.Errorf("Decode(%q) #%d = %q %q, want %q %q", tt.request, i, actual.Name, actual.Args, expected.Name, expected.Args)
+			}
+		}
+		if decoder.Buffered() != 0 {
+			t.Errorf("Decode(%q) left %d bytes buffered", tt.request, decoder.Buffered())
+		}
+	}
+}
+
+var decodeErrorTests = []string{
+	"*1\r\n+PING\r\n",
+	"*x\r\n",
+	"*1\r\n$-1\r\n",
+	"*1\r\n$4\r\nPINGx\r\n",
+	"*1\r\n$4\r\nPI",
+	"*1\n$4\r\nPING\r\n",
+	"SET \"foo bar\r\n",
+	"SET 'foo'bar\r\n",
+	// 超过上限或溢出的长度
+	"*99999999999999\r\n",
+	"*99999999999999999999999999\r\n",
+	"*1\r\n$536870913\r\n",
+	"*1\r\n*1\r\n$4\r\nPING\r\n",
+}
+
+func TestDecodeError(t *testing.T) {
+	for _, request := range decodeErrorTests {
+		decoder := NewDecoder(strings.NewReader(request), 4096)
+		if _, err := decoder.Decode(); err == nil {
+			t.Errorf("Decode(%q) did not return expected error", request)
+		}
+	}
+}
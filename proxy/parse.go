@@ -18,6 +18,10 @@ func parseLen(p []byte) (int, error) {
 			return -1, protocolError("illegal bytes in length")
 		}
 		n += int(b - '0')
+		if n > maxBulkLen {
+			// 超过任何上限的长度不再累加，避免溢出
+			return -1, protocolError("length out of range")
+		}
 	}
 
 	return n, nil
@@ -53,3 +57,95 @@ func parseInt(p []byte) (interface{}, error) {
 	return n, nil
 }
 
+// ParseInline splits an inline command line into arguments the same way
+// redis-server does: arguments are separated by spaces and may be quoted
+// with "double quotes" (supporting \n, \r, \t, \b, \a, \" and \xHH escapes)
+// or 'single quotes' (supporting \').
+func ParseInline(line []byte) ([][]byte, error) {
+	var args [][]byte
+	i := 0
+	for {
+		for i < len(line) && isSpace(line[i]) {
+			i++
+		}
+		if i == len(line) {
+			return args, nil
+		}
+		arg := []byte{}
+		switch line[i] {
+		case '"':
+			for i++; ; i++ {
+				if i == len(line) {
+					return nil, protocolError("unbalanced quotes in request")
+				}
+				if line[i] == '"' {
+					break
+				}
+				if line[i] == '\\' && i+3 < len(line) && line[i+1] == 'x' && isHex(line[i+2]) && isHex(line[i+3]) {
+					arg = append(arg, hexValue(line[i+2])<<4|hexValue(line[i+3]))
+					i += 3
+				} else if line[i] == '\\' && i+1 < len(line) {
+					i++
+					switch line[i] {
+					case 'n':
+						arg = append(arg, '\n')
+					case 'r':
+						arg = append(arg, '\r')
+					case 't':
+						arg = append(arg, '\t')
+					case 'b':
+						arg = append(arg, '\b')
+					case 'a':
+						arg = append(arg, '\a')
+					default:
+						arg = append(arg, line[i])
+					}
+				} else {
+					arg = append(arg, line[i])
+				}
+			}
+			i++
+		case '\'':
+			for i++; ; i++ {
+				if i == len(line) {
+					return nil, protocolError("unbalanced quotes in request")
+				}
+				if line[i] == '\'' {
+					break
+				}
+				if line[i] == '\\' && i+1 < len(line) && line[i+1] == '\'' {
+					i++
+				}
+				arg = append(arg, line[i])
+			}
+			i++
+		default:
+			for ; i < len(line) && !isSpace(line[i]); i++ {
+				arg = append(arg, line[i])
+			}
+		}
+		// closing quote must be followed by a space or nothing at all.
+		if i < len(line) && !isSpace(line[i]) {
+			return nil, protocolError("unbalanced quotes in request")
+		}
+		args = append(args, arg)
+	}
+}
+
+func isSpace(b byte) bool {
+	return b == ' ' || b == '\t' || b == '\n' || b == '\r' || b == '\v' || b == '\f' || b == 0
+}
+
+func isHex(b byte) bool {
+	return (b >= '0' && b <= '9') || (b >= 'a' && b <= 'f') || (b >= 'A' && b <= 'F')
+}
+
+func hexValue(b byte) byte {
+	switch {
+	case b >= 'a':
+		return b - 'a' + 10
+	case b >= 'A':
+		return b - 'A' + 10
+	}
+	return b - '0'
+}
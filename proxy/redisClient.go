@@ -3,27 +3,27 @@ package proxy
 import (
 	"net"
 	"sync"
-	"bufio"
 	"time"
 	//"io"
 	//"strconv"
 	//"bytes"
-	"fmt"
+	"redisProxy/logger"
 )
 
 type redisClient struct {
 	conn		net.Conn
 	tcpServer	*tcpServer
+	log		*logger.Logger	// 带有客户端地址的日志
 	mu		sync.Mutex
 	err		error
 	pending		int
 
 	// Read
-	br		*bufio.Reader
+	decoder		*Decoder
 	readTimeout	time.Duration
 
 	// Write
-	bw		*bufio.Writer
+	writer		*Writer
 	writeTimeout	time.Duration
 
 
@@ -91,29 +91,42 @@ func (redisClient *redisClient) SendBytes(b []byte) error{
 }
 
 /*
-*	逐行读取redisClient客户端发送的数据
+*	按RESP原始类型回复redis客户端
+ */
+func (redisClient *redisClient) WriteReply(reply interface{}) error{
+	if err := redisClient.writer.WriteReply(reply); err != nil {
+		return err
+	}
+	return redisClient.writer.Flush()
+}
+
+/*
+*	从redisClient客户端连接中解码完整命令，交给onNewMessage回调处理
  */
 func (redisClient *redisClient) readMessage() {
-	reader := bufio.NewReader(redisClient.conn)
-	result := make(chan []byte, redisClient.tcpServer.receiveChanSize)
-	// 迭代接收buffer中数据
+	redisClient.decoder = NewDecoder(redisClient.conn, 4096)
+	result := make(chan *Command, redisClient.tcpServer.receiveChanSize)
+	// 迭代解码buffer中的命令
 	go func() {
+		defer close(result)
 		for {
-			message, err := reader.ReadSlice('\n')
+			command, err := redisClient.decoder.Decode()
 			if err != nil {
-				redisClient.conn.Close()
+				if pe, ok := err.(protocolError); ok {
+					redisClient.log.Warn("protocol error, closing the client connection", "err", err)
+					redisClient.Send("-ERR Protocol error: " + string(pe) + "\r\n")
+				} else {
+					redisClient.log.Debug("client disconnected", "err", err)
+				}
+				redisClient.Fatal(err)
 				redisClient.tcpServer.onRedisClientConnectionClosed(redisClient, err)
 				return
 			}
-			i := len(message) - 2
-			if i < 0 || message[i] != '\r' {
-				return
-			}
-			result <- message[:i]
+			result <- command
 		}
 	}()
 
-	//接收完后 发送数据到tcp服务端
+	//解码后 发送命令到tcp服务端
 	go func() {
 		redisClient.tcpServer.onNewMessage(redisClient, result)
 	}()
@@ -121,7 +134,7 @@ func (redisClient *redisClient) readMessage() {
 
 //**** redisClient入口，其中执行业务逻辑
 func (redisClient *redisClient) listen() {
-	fmt.Println("请在redisClient中执行业务逻辑，并读取redisClient中buffer的信息")
+	redisClient.log.Debug("client connected")
 	redisClient.readMessage()
 }
 
@@ -0,0 +1,135 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+/*
+*	RESP回复编码器：按后端回复的原始类型写回客户端
+*	string为状态回复，error为错误回复，[]byte为bulk string，
+*	int64为整数回复，nil为nil bulk string($-1)，[]interface{}为(嵌套)数组，
+*	nil的[]interface{}为nil数组(*-1)，如BLPOP超时和WATCH失败后EXEC的回复
+ */
+type Writer struct {
+	bw	*bufio.Writer
+
+	// Scratch space for formatting argument length.
+	// '*' or '$', length, "\r\n"
+	lenScratch [32]byte
+
+	// Scratch space for formatting integers.
+	numScratch [40]byte
+}
+
+/*
+*	创建编码器，size为写缓冲区大小
+ */
+func NewWriter(w io.Writer, size int) *Writer {
+	return &Writer{
+		bw: bufio.NewWriterSize(w, size),
+	}
+}
+
+func (writer *Writer) writeLen(prefix byte, n int) error {
+	writer.lenScratch[len(writer.lenScratch)-1] = '\n'
+	writer.lenScratch[len(writer.lenScratch)-2] = '\r'
+	i := len(writer.lenScratch) - 3
+	for {
+		writer.lenScratch[i] = byte('0' + n%10)
+		i -= 1
+		n = n / 10
+		if n == 0 {
+			break
+		}
+	}
+	writer.lenScratch[i] = prefix
+	_, err := writer.bw.Write(writer.lenScratch[i:])
+	return err
+}
+
+func (writer *Writer) writeLine(prefix byte, s string) error {
+	writer.bw.WriteByte(prefix)
+	writer.bw.WriteString(s)
+	_, err := writer.bw.WriteString("\r\n")
+	return err
+}
+
+func (writer *Writer) writeBytes(p []byte) error {
+	writer.writeLen('$', len(p))
+	writer.bw.Write(p)
+	_, err := writer.bw.WriteString("\r\n")
+	return err
+}
+
+/*
+*	状态回复，如 +OK
+ */
+func (writer *Writer) WriteStatus(status string) error {
+	return writer.writeLine('+', status)
+}
+
+/*
+*	错误回复，如 -ERR unknown command
+ */
+func (writer *Writer) WriteError(message string) error {
+	return writer.writeLine('-', message)
+}
+
+/*
+*	整数回复，如 :1
+ */
+func (writer *Writer) WriteInt64(n int64) error {
+	writer.bw.WriteByte(':')
+	writer.bw.Write(strconv.AppendInt(writer.numScratch[:0], n, 10))
+	_, err := writer.bw.WriteString("\r\n")
+	return err
+}
+
+/*
+*	按原始类型编码一条回复
+ */
+func (writer *Writer) WriteReply(reply interface{}) error {
+	switch reply := reply.(type) {
+	case nil:
+		_, err := writer.bw.WriteString("$-1\r\n")
+		return err
+	case string:
+		return writer.WriteStatus(reply)
+	case error:
+		return writer.WriteError(reply.Error())
+	case []byte:
+		return writer.writeBytes(reply)
+	case int64:
+		return writer.WriteInt64(reply)
+	case int:
+		return writer.WriteInt64(int64(reply))
+	case []interface{}:
+		if reply == nil {
+			_, err := writer.bw.WriteString("*-1\r\n")
+			return err
+		}
+		err := writer.writeLen('*', len(reply))
+		for _, r := range reply {
+			if err != nil {
+				break
+			}
+			err = writer.WriteReply(r)
+		}
+		return err
+	default:
+		var buf bytes.Buffer
+		fmt.Fprint(&buf, reply)
+		return writer.writeBytes(buf.Bytes())
+	}
+}
+
+/*
+*	发送写缓冲区中的回复
+ */
+func (writer *Writer) Flush() error {
+	return writer.bw.Flush()
+}
@@ -0,0 +1,37 @@
+package proxy
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+var writeReplyTests = []struct {
+	reply    interface{}
+	expected string
+}{
+	{"OK", "+OK\r\n"},
+	{errors.New("ERR bad"), "-ERR bad\r\n"},
+	{int64(-2), ":-2\r\n"},
+	{[]byte("foo"), "$3\r\nfoo\r\n"},
+	{[]byte{}, "$0\r\n\r\n"},
+	// nil bulk string和nil数组保持各自的类型
+	{nil, "$-1\r\n"},
+	{[]interface{}(nil), "*-1\r\n"},
+	{[]interface{}{}, "*0\r\n"},
+	{[]interface{}{[]byte("a"), nil, []interface{}(nil), []interface{}{int64(1)}}, "*4\r\n$1\r\na\r\n$-1\r\n*-1\r\n*1\r\n:1\r\n"},
+}
+
+func TestWriteReply(t *testing.T) {
+	for _, tt := range writeReplyTests {
+		var buf bytes.Buffer
+		writer := NewWriter(&buf, 64)
+		if err := writer.WriteReply(tt.reply); err != nil {
+			t.Errorf("WriteReply(%#v) returned error %v", tt.reply, err)
+		}
+		writer.Flush()
+		if buf.String() != tt.expected {
+			t.Errorf("WriteReply(%#v) wrote %q, want %q", tt.reply, buf.String(), tt.expected)
+		}
+	}
+}
@@ -2,16 +2,19 @@ package proxy
 
 import (
 	"net"
-	"log"
+	"os"
+	"time"
+	"redisProxy/logger"
 )
 
 type tcpServer struct {
 	redisClients			[]*redisClient
 	address				string
 	receiveChanSize			int
+	log				*logger.Logger
 	onNewRedisClientCallback	func(redisClient *redisClient)
 	onRedisClientConnectionClosed	func(redisClient *redisClient, err error)
-	onNewMessage			func(redisClient *redisClient, message chan []byte)
+	onNewMessage			func(redisClient *redisClient, commands chan *Command)
 }
 
 /*
@@ -31,25 +34,44 @@ func (tcpServer *tcpServer) OnRedisClientConnectionClosed(callback func(redisCli
 /*
 *	新消息回调方法
  */
-func (tcpServer *tcpServer) OnNewMessage(callback func(redisClient *redisClient, message chan []byte)){
+func (tcpServer *tcpServer) OnNewMessage(callback func(redisClient *redisClient, commands chan *Command)){
 	tcpServer.onNewMessage = callback
 }
 
 /*
-*	监听client连接
+*	替换默认输出到stderr的日志，在Listen之前调用
  */
-func (tcpServer *tcpServer) Listen() {
+func (tcpServer *tcpServer) SetLogger(log *logger.Logger){
+	tcpServer.log = log
+}
+
+/*
+*	监听client连接，监听失败或Accept出现非临时错误时返回
+ */
+func (tcpServer *tcpServer) Listen() error {
 	listener, err := net.Listen("tcp", tcpServer.address)
 	if err != nil {
-		log.Fatal("Error starting TCP server")
+		tcpServer.log.Error("cannot start tcp server", "listen", tcpServer.address, "err", err)
+		return err
 	}
 	defer listener.Close()
 
 	for {
-		conn, _ := listener.Accept()
+		conn, err := listener.Accept()
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				tcpServer.log.Warn("accept error, retrying", "err", err)
+				time.Sleep(5 * time.Millisecond)
+				continue
+			}
+			tcpServer.log.Error("accept failed", "err", err)
+			return err
+		}
 		redisClient := &redisClient{
 			conn: conn,
 			tcpServer: tcpServer,
+			writer: NewWriter(conn, 4096),
+			log: tcpServer.log.With("remote_addr", conn.RemoteAddr().String()),
 		}
 		go redisClient.listen()	//****循环监听多个redisClient
 		tcpServer.onNewRedisClientCallback(redisClient)
@@ -64,13 +86,14 @@ func (tcpServer *tcpServer) Listen() {
 *
  */
 func New(address string, rcSize int) *tcpServer {
-	log.Println("Creating server with address", address)
 	tcpServer := &tcpServer{
 		address:address,
 		receiveChanSize:rcSize,
+		log:logger.New(os.Stderr, false, logger.InfoLevel),
 	}
+	tcpServer.log.Info("creating server", "listen", address)
 	tcpServer.OnNewRedisClient(func(redisClient *redisClient) {})
-	tcpServer.OnNewMessage(func(redisClient *redisClient, message chan []byte) {})
+	tcpServer.OnNewMessage(func(redisClient *redisClient, commands chan *Command) {})
 	tcpServer.OnRedisClientConnectionClosed(func(redisClient *redisClient, err error) {})
 	return tcpServer
 }
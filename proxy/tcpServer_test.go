@@ -19,10 +19,10 @@ func TestNew(t *testing.T) {
 	})
 
 	// 异步处理client消息
-	tcpServer.OnNewMessage(func(redisClient *redisClient, message chan []byte) {
-		redisClient.SendBytes(hello)
-		for {
-			fmt.Print(string(<-message))
+	tcpServer.OnNewMessage(func(redisClient *redisClient, commands chan *Command) {
+		for command := range commands {
+			redisClient.SendBytes(hello)
+			fmt.Println(string(command.Name), len(command.Args))
 		}
 	})
 
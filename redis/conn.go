@@ -12,6 +12,7 @@ import (
 	"bytes"
 	"io"
 	"regexp"
+	"crypto/tls"
 )
 
 /*
@@ -57,7 +58,10 @@ type dialOptions struct {
 	dial		func(network, addr string) (net.Conn, error)
 	db		int
 	password	string
+	connectTimeout	time.Duration
+	useTLS		bool
 	skipVerify	bool
+	tlsConfig	*tls.Config
 }
 
 /*
@@ -66,7 +70,7 @@ type dialOptions struct {
 	返回值：net.Conn连接，error
 ****************************************
  */
-func Dial(network, address string, options ...DialOption) (*conn, error){
+func Dial(network, address string, options ...DialOption) (Conn, error){
 	do := dialOptions{
 		dial: net.Dial,
 	}
@@ -79,6 +83,15 @@ func Dial(network, address string, options ...DialOption) (*conn, error){
 		return nil, err
 	}
 
+	if do.useTLS {
+		tlsConn, err := dialTLS(netConn, address, &do)
+		if err != nil {
+			netConn.Close()
+			return nil, err
+		}
+		netConn = tlsConn
+	}
+
 	c := &conn{
 		conn:		netConn,
 		bw:		bufio.NewWriter(netConn),
@@ -103,12 +116,43 @@ func Dial(network, address string, options ...DialOption) (*conn, error){
 	return c, nil
 }
 
+/*
+	TLS握手：ServerName默认取地址中的host，握手受连接超时限制
+ */
+func dialTLS(netConn net.Conn, address string, do *dialOptions) (net.Conn, error) {
+	var config *tls.Config
+	if do.tlsConfig == nil {
+		config = &tls.Config{}
+	} else {
+		config = do.tlsConfig.Clone()
+	}
+	if do.skipVerify {
+		config.InsecureSkipVerify = true
+	}
+	if config.ServerName == "" {
+		host, _, err := net.SplitHostPort(address)
+		if err != nil {
+			host = address
+		}
+		config.ServerName = host
+	}
+	tlsConn := tls.Client(netConn, config)
+	if do.connectTimeout != 0 {
+		tlsConn.SetDeadline(time.Now().Add(do.connectTimeout))
+	}
+	if err := tlsConn.Handshake(); err != nil {
+		return nil, err
+	}
+	tlsConn.SetDeadline(time.Time{})
+	return tlsConn, nil
+}
+
 var pathDBRegexp = regexp.MustCompile(`/(\d*)\z`)
 /*
 	URL拨号方法（链接预处理：TLS、password...）
 	返回值：回调Dial方法，返回Conn连接
  */
-func DialURL(rawurl string, options ...DialOption) (*conn, error){
+func DialURL(rawurl string, options ...DialOption) (Conn, error){
 	u, err := url.Parse(rawurl)
 	if err != nil{
 		return nil, err
@@ -151,6 +195,8 @@ func DialURL(rawurl string, options ...DialOption) (*conn, error){
 		return nil, fmt.Errorf("invalid database: %s", u.Path[1:])
 	}
 
+	options = append(options, DialUseTLS(u.Scheme == "rediss"))
+
 	return Dial("tcp", address, options...)
 }
 
@@ -158,7 +204,7 @@ func DialURL(rawurl string, options ...DialOption) (*conn, error){
 	超时信息构造函数
 	返回值： DialOption配置实体
  */
-func DialTimeout(network, address string, connectTimeout, readTimeout, writeTimeout time.Duration) (*conn, error){
+func DialTimeout(network, address string, connectTimeout, readTimeout, writeTimeout time.Duration) (Conn, error){
 	return Dial(network, address,
 		DialConnectTimeout(connectTimeout),
 		DialReadTimeout(readTimeout),
@@ -181,6 +227,7 @@ func DialConnectTimeout(d time.Duration) DialOption{
 	return DialOption{func(do *dialOptions) {
 		dialer := net.Dialer{Timeout:d}
 		do.dial = dialer.Dial
+		do.connectTimeout = d
 	}}
 }
 
@@ -206,6 +253,28 @@ func DialPassword(password string) DialOption {
 	}}
 }
 
+/*
+	TLS配置：DialUseTLS开启TLS，DialTLSConfig指定CA、客户端证书、ServerName等，
+	DialTLSSkipVerify跳过服务端证书校验
+ */
+func DialUseTLS(useTLS bool) DialOption {
+	return DialOption{func(do *dialOptions) {
+		do.useTLS = useTLS
+	}}
+}
+
+func DialTLSConfig(c *tls.Config) DialOption {
+	return DialOption{func(do *dialOptions) {
+		do.tlsConfig = c
+	}}
+}
+
+func DialTLSSkipVerify(skip bool) DialOption {
+	return DialOption{func(do *dialOptions) {
+		do.skipVerify = skip
+	}}
+}
+
 
 /*
 ****************************************
@@ -224,7 +293,7 @@ func NewCon(netConn net.Conn, readTimeout, writeTimeout time.Duration) *conn{
 }
 
 /*
-	连接Conn构造函数: Close()、fatal()、Err()、writeLen()、writeString、writeBytes、
+	连接Conn构造函数: Close()、Err()、fatal()、writeLen()、writeString、writeBytes、
 	writeInt64()、 writeFloat64、 writeCommand()、Do()、Receive()、Flush()、Send()
  */
 func (c *conn) Close() error  {
@@ -238,6 +307,13 @@ func (c *conn) Close() error  {
 	return err
 }
 
+func (c *conn) Err() error {
+	c.mu.Lock()
+	err := c.err
+	c.mu.Unlock()
+	return err
+}
+
 func (c *conn) fatal(err error) error {
 	c.mu.Lock()
 	if c.err == nil{
@@ -430,7 +506,7 @@ func (c *conn) readReply() (interface{}, error){
 			return string(line[1:]), nil
 		}
 	case '-':
-		return string(line[1:]), nil
+		return Error(string(line[1:])), nil
 	case ':':
 		return parseInt(line[1:])
 	case '$':
@@ -451,9 +527,13 @@ func (c *conn) readReply() (interface{}, error){
 		return p, nil
 	case '*':
 		n, err := parseLen(line[1:])
-		if n < 0 || err != nil {
+		if err != nil {
 			return nil, err
 		}
+		if n < 0 {
+			// nil数组(*-1)返回nil切片，与nil bulk string($-1)区分
+			return []interface{}(nil), nil
+		}
 		r := make([]interface{}, n)
 		for i := range r{
 			r[i], err = c.readReply()
@@ -499,9 +579,18 @@ func (c *conn) Flush() error {
 	接收消息
  */
 func (c *conn) Receive() (reply interface{}, err error) {
-	if c.readTimeout != 0 {
-		c.conn.SetDeadline(time.Now().Add(c.readTimeout))
+	return c.ReceiveWithTimeout(c.readTimeout)
+}
+
+/*
+	接收消息，timeout代替默认的读超时，0表示不超时
+ */
+func (c *conn) ReceiveWithTimeout(timeout time.Duration) (reply interface{}, err error) {
+	var deadline time.Time
+	if timeout != 0 {
+		deadline = time.Now().Add(timeout)
 	}
+	c.conn.SetReadDeadline(deadline)
 	if reply, err = c.readReply(); err != nil {
 		return nil, c.fatal(err)
 	}
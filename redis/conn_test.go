@@ -130,7 +130,7 @@ var readTests = []struct {
 	},
 	{
 		"*-1\r\n",
-		nil,
+		[]interface{}(nil),
 	},
 	{
 		"*4\r\n$3\r\nfoo\r\n$3\r\nbar\r\n$5\r\nHello\r\n$5\r\nWorld\r\n",
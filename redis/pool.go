@@ -55,6 +55,12 @@ type Pool struct {
 	closed bool
 	active int
 
+	// statistics, see Stats
+	waitCount	int64
+	waitDuration	time.Duration
+	dialErrors	int64
+	testFailures	int64
+
 	// Stack of idleConn with most recently used at the front.
 	idle list.List
 }
@@ -95,6 +101,37 @@ func (p *Pool) ActiveCount() int {
 	return active
 }
 
+// PoolStats contains pool statistics.
+type PoolStats struct {
+	// ActiveCount is the number of connections in the pool, idle ones included.
+	ActiveCount int
+	// IdleCount is the number of idle connections in the pool.
+	IdleCount int
+	// WaitCount is the number of times Get waited for a connection to be
+	// returned, WaitDuration is the total time spent waiting.
+	WaitCount	int64
+	WaitDuration	time.Duration
+	// DialErrors is the number of failed dials.
+	DialErrors int64
+	// TestFailures is the number of idle connections closed because
+	// TestOnBorrow failed.
+	TestFailures int64
+}
+
+// Stats returns pool statistics.
+func (p *Pool) Stats() PoolStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return PoolStats{
+		ActiveCount: p.active,
+		IdleCount: p.idle.Len(),
+		WaitCount: p.waitCount,
+		WaitDuration: p.waitDuration,
+		DialErrors: p.dialErrors,
+		TestFailures: p.testFailures,
+	}
+}
+
 // Close releases the resources used by the pool.
 func (p *Pool) Close() error {
 	p.mu.Lock()
@@ -149,6 +186,7 @@ func (p *Pool) get() (Conn, error) {
 		}
 	}
 
+	waited := false
 	for {
 
 		// Get idle connection.
@@ -167,6 +205,7 @@ func (p *Pool) get() (Conn, error) {
 			}
 			ic.c.Close()
 			p.mu.Lock()
+			p.testFailures++
 			p.release()
 		}
 
@@ -186,6 +225,7 @@ func (p *Pool) get() (Conn, error) {
 			c, err := dial()
 			if err != nil {
 				p.mu.Lock()
+				p.dialErrors++
 				p.release()
 				p.mu.Unlock()
 				c = nil
@@ -201,7 +241,13 @@ func (p *Pool) get() (Conn, error) {
 		if p.cond == nil {
 			p.cond = sync.NewCond(&p.mu)
 		}
+		start := nowFunc()
 		p.cond.Wait()
+		if !waited {
+			waited = true
+			p.waitCount++
+		}
+		p.waitDuration += nowFunc().Sub(start)
 	}
 }
 
@@ -330,6 +376,10 @@ func (pc *pooledConnection) Receive() (reply interface{}, err error) {
 	return pc.c.Receive()
 }
 
+func (pc *pooledConnection) ReceiveWithTimeout(timeout time.Duration) (reply interface{}, err error) {
+	return ReceiveWithTimeout(pc.c, timeout)
+}
+
 type errorConnection struct{ err error }
 
 func (ec errorConnection) Do(string, ...interface{}) (interface{}, error) { return nil, ec.err }
@@ -338,3 +388,4 @@ func (ec errorConnection) Err() error                                     { retu
 func (ec errorConnection) Close() error                                   { return ec.err }
 func (ec errorConnection) Flush() error                                   { return ec.err }
 func (ec errorConnection) Receive() (interface{}, error)                  { return nil, ec.err }
+func (ec errorConnection) ReceiveWithTimeout(time.Duration) (interface{}, error) { return nil, ec.err }
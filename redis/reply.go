@@ -217,6 +217,7 @@ func MultiBulk(reply interface{}, err error) ([]interface{}, error) { return Val
 //
 //  Reply type      Result
 //  array           reply, nil
+//  nil array       nil, ErrNil
 //  nil             nil, ErrNil
 //  other           nil, error
 func Values(reply interface{}, err error) ([]interface{}, error) {
@@ -225,6 +226,9 @@ func Values(reply interface{}, err error) ([]interface{}, error) {
 	}
 	switch reply := reply.(type) {
 	case []interface{}:
+		if reply == nil {
+			return nil, ErrNil
+		}
 		return reply, nil
 	case nil:
 		return nil, ErrNil
@@ -244,6 +248,9 @@ func Strings(reply interface{}, err error) ([]string, error) {
 	}
 	switch reply := reply.(type) {
 	case []interface{}:
+		if reply == nil {
+			return nil, ErrNil
+		}
 		result := make([]string, len(reply))
 		for i := range reply {
 			if reply[i] == nil {
@@ -274,6 +281,9 @@ func ByteSlices(reply interface{}, err error) ([][]byte, error) {
 	}
 	switch reply := reply.(type) {
 	case []interface{}:
+		if reply == nil {
+			return nil, ErrNil
+		}
 		result := make([][]byte, len(reply))
 		for i := range reply {
 			if reply[i] == nil {